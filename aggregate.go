@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dimension is an attribute of a query frame that frames can be grouped by
+type Dimension struct {
+	Name string
+	// values returns the group values of the frame for this dimension.
+	// a frame with several values (e.g. every tag) is counted in each group
+	values func(frame *Frame) []string
+}
+
+// ParseDimension parses a dimension spec, one of:
+// fingerprint, stream, command, error (the error code of failed queries),
+// tag (every tag as key:value) or tag:<key>
+func ParseDimension(spec string) (Dimension, error) {
+	spec = strings.TrimSpace(spec)
+
+	switch {
+	case spec == "fingerprint":
+		return Dimension{Name: spec, values: func(frame *Frame) []string {
+			return []string{frame.MySQLQuery.Fingerprint}
+		}}, nil
+	case spec == "stream":
+		return Dimension{Name: spec, values: func(frame *Frame) []string {
+			return []string{strconv.Itoa(frame.TCPStream)}
+		}}, nil
+	case spec == "command":
+		return Dimension{Name: spec, values: func(frame *Frame) []string {
+			return []string{strconv.Itoa(frame.MySQLCommand)}
+		}}, nil
+	case spec == "error":
+		return Dimension{Name: spec, values: func(frame *Frame) []string {
+			if frame.MySQLQuery.ErrorCode == 0 {
+				return nil
+			}
+			return []string{strconv.Itoa(frame.MySQLQuery.ErrorCode)}
+		}}, nil
+	case spec == "tag":
+		return Dimension{Name: spec, values: func(frame *Frame) []string {
+			var result []string
+			for k, v := range frame.MySQLQuery.Tags {
+				result = append(result, k+":"+v)
+			}
+			return result
+		}}, nil
+	case strings.HasPrefix(spec, "tag:") && len(spec) > len("tag:"):
+		key := strings.TrimPrefix(spec, "tag:")
+		return Dimension{Name: spec, values: func(frame *Frame) []string {
+			if v, ok := frame.MySQLQuery.Tags[key]; ok {
+				return []string{v}
+			}
+			return nil
+		}}, nil
+	}
+
+	return Dimension{}, fmt.Errorf("unknown dimension %q", spec)
+}
+
+// ParseDimensions parses a comma separated list of dimension specs
+func ParseDimensions(spec string) ([]Dimension, error) {
+	var result []Dimension
+	for _, s := range strings.Split(spec, ",") {
+		dimension, err := ParseDimension(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dimension)
+	}
+	return result, nil
+}
+
+// Metric is a statistic reported for each group: count, sum, min, mean, max
+// or a percentile such as p50 or p99.9
+type Metric string
+
+const (
+	MetricCount Metric = "count"
+	MetricSum   Metric = "sum"
+	MetricMin   Metric = "min"
+	MetricMean  Metric = "mean"
+	MetricMax   Metric = "max"
+)
+
+// DefaultMetrics are the metrics reported unless others are asked for: count,
+// sum, the configured percentiles and max
+func DefaultMetrics(percentiles []float64) []Metric {
+	result := []Metric{MetricCount, MetricSum}
+	for _, p := range percentiles {
+		result = append(result, Metric(Percentile{Percentile: p}.Name()))
+	}
+	return append(result, MetricMax)
+}
+
+// ParseMetrics parses a comma separated list of metrics
+func ParseMetrics(spec string) ([]Metric, error) {
+	var result []Metric
+	for _, s := range strings.Split(spec, ",") {
+		metric := Metric(strings.TrimSpace(s))
+		switch metric {
+		case MetricCount, MetricSum, MetricMin, MetricMean, MetricMax:
+		default:
+			if _, ok := metric.Percentile(); !ok {
+				return nil, fmt.Errorf("unknown metric %q", s)
+			}
+		}
+		result = append(result, metric)
+	}
+	return result, nil
+}
+
+// Percentile returns the percentile of a pNN metric
+func (m Metric) Percentile() (float64, bool) {
+	if !strings.HasPrefix(string(m), "p") {
+		return 0, false
+	}
+	percentiles, err := ParsePercentiles(string(m))
+	if err != nil {
+		return 0, false
+	}
+	return percentiles[0], true
+}
+
+// Value returns the metric from the statistics formatted for output.
+// durations are reported in milliseconds
+func (m Metric) Value(ts TimeStatistics) string {
+	var d time.Duration
+	switch m {
+	case MetricCount:
+		return strconv.Itoa(ts.Count)
+	case MetricSum:
+		d = ts.Sum
+	case MetricMin:
+		d = ts.Min
+	case MetricMean:
+		d = ts.Mean
+	case MetricMax:
+		d = ts.Max
+	default:
+		if p, ok := m.Percentile(); ok {
+			d, _ = ts.Percentile(p)
+		}
+	}
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// Filter restricts a GroupBy to frames that have the given value for a dimension
+type Filter struct {
+	Dimension Dimension
+	Value     string
+}
+
+func (f Filter) match(frame *Frame) bool {
+	for _, v := range f.Dimension.values(frame) {
+		if v == f.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupBy aggregates query durations for every combination of dimension values
+type GroupBy struct {
+	config     StatisticsConfig
+	dimensions []Dimension
+	filters    []Filter
+	// groups is keyed by the group values joined with a tab
+	groups map[string]*Group
+}
+
+type Group struct {
+	Values    []string   `json:"values"`
+	Durations *Histogram `json:"durations"`
+	// Sources breaks the group down by the capture it was seen in,
+	// set when the group is merged from summaries
+	Sources map[string]*SourceTotals `json:"sources,omitempty"`
+}
+
+func NewGroupBy(config StatisticsConfig, dimensions []Dimension, filters ...Filter) GroupBy {
+	return GroupBy{
+		config:     config,
+		dimensions: dimensions,
+		filters:    filters,
+		groups:     make(map[string]*Group),
+	}
+}
+
+// AddFrame adds the frame to every group it belongs to.
+// frames that are not queries are ignored
+func (g *GroupBy) AddFrame(frame *Frame) {
+	if frame.MySQLQuery.Fingerprint == "" {
+		return
+	}
+
+	for _, filter := range g.filters {
+		if !filter.match(frame) {
+			return
+		}
+	}
+
+	// build the cartesian product of the values of each dimension
+	combinations := [][]string{{}}
+	for _, dimension := range g.dimensions {
+		var next [][]string
+		for _, value := range dimension.values(frame) {
+			for _, combination := range combinations {
+				values := make([]string, len(combination), len(combination)+1)
+				copy(values, combination)
+				next = append(next, append(values, value))
+			}
+		}
+		combinations = next
+	}
+
+	for _, values := range combinations {
+		key := strings.Join(values, "\t")
+		group, ok := g.groups[key]
+		if !ok {
+			group = &Group{Values: values, Durations: NewHistogram(g.config.RelativeError)}
+			g.groups[key] = group
+		}
+		group.Durations.Add(frame.MySQLQuery.Duration)
+	}
+}
+
+// Spec returns the dimensions of the GroupBy in the format of ParseDimensions
+func (g *GroupBy) Spec() string {
+	var names []string
+	for _, dimension := range g.dimensions {
+		names = append(names, dimension.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Merge adds the groups of another GroupBy over the same dimensions
+func (g *GroupBy) Merge(other *GroupBy) error {
+	if g.Spec() != other.Spec() {
+		return fmt.Errorf("cannot merge groups by %q into groups by %q", other.Spec(), g.Spec())
+	}
+
+	for key, group := range other.groups {
+		if _, ok := g.groups[key]; !ok {
+			g.groups[key] = &Group{Values: group.Values, Durations: NewHistogram(g.config.RelativeError)}
+		}
+
+		if err := g.groups[key].Durations.Merge(group.Durations); err != nil {
+			return err
+		}
+		g.groups[key].Sources = mergeSourceTotals(g.groups[key].Sources, group.Sources)
+	}
+
+	return nil
+}
+
+// Groups returns the groups ordered by descending count
+func (g *GroupBy) Groups() []*Group {
+	result := make([]*Group, 0, len(g.groups))
+	for _, group := range g.groups {
+		result = append(result, group)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Durations.Count != result[j].Durations.Count {
+			return result[i].Durations.Count > result[j].Durations.Count
+		}
+		return strings.Join(result[i].Values, "\t") < strings.Join(result[j].Values, "\t")
+	})
+
+	return result
+}
+
+func (g *GroupBy) TSV(metrics []Metric) (string, error) {
+	var builder strings.Builder
+
+	var percentiles []float64
+	for _, metric := range metrics {
+		if p, ok := metric.Percentile(); ok {
+			percentiles = append(percentiles, p)
+		}
+	}
+
+	for _, dimension := range g.dimensions {
+		builder.WriteString(dimension.Name)
+		builder.WriteString("\t")
+	}
+	for i, metric := range metrics {
+		if i > 0 {
+			builder.WriteString("\t")
+		}
+		builder.WriteString(string(metric))
+	}
+	builder.WriteString("\n")
+
+	for _, group := range g.Groups() {
+		ts, err := NewTimeStatistics(group.Durations, percentiles)
+		if err != nil {
+			return "", err
+		}
+
+		for _, value := range group.Values {
+			builder.WriteString(value)
+			builder.WriteString("\t")
+		}
+		for i, metric := range metrics {
+			if i > 0 {
+				builder.WriteString("\t")
+			}
+			builder.WriteString(metric.Value(ts))
+		}
+		builder.WriteString("\n")
+	}
+
+	return builder.String(), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupBy(t *testing.T) {
+	frames := Frames{
+		{TCPStream: 1, MySQLQuery: MySQLQuery{Fingerprint: "a", Tags: map[string]string{"controller": "x", "job": "j"}, Duration: 1 * time.Millisecond}},
+		{TCPStream: 1, MySQLQuery: MySQLQuery{Fingerprint: "a", Tags: map[string]string{"controller": "y"}, Duration: 3 * time.Millisecond}},
+		{TCPStream: 2, MySQLQuery: MySQLQuery{Fingerprint: "b", Tags: map[string]string{"controller": "x"}, Duration: 5 * time.Millisecond}},
+		// not a query
+		{TCPStream: 2, TCPFin: true},
+	}
+
+	dimensions, err := ParseDimensions("tag:controller,fingerprint")
+	assert.NoError(t, err)
+
+	gb := NewGroupBy(DefaultStatisticsConfig(), dimensions)
+	for _, frame := range frames {
+		gb.AddFrame(frame)
+	}
+
+	groups := gb.Groups()
+	assert.Len(t, groups, 3)
+	assert.Equal(t, []string{"x", "a"}, groups[0].Values)
+
+	tsv, err := gb.TSV([]Metric{MetricCount, MetricSum})
+	assert.NoError(t, err)
+	assert.Equal(t, "tag:controller\tfingerprint\tcount\tsum\nx\ta\t1\t1.000\nx\tb\t1\t5.000\ny\ta\t1\t3.000\n", tsv)
+
+	// every tag is its own group
+	tags := NewGroupBy(DefaultStatisticsConfig(), []Dimension{mustDimension(t, "tag")}, Filter{Dimension: mustDimension(t, "fingerprint"), Value: "a"})
+	for _, frame := range frames {
+		tags.AddFrame(frame)
+	}
+	assert.Len(t, tags.Groups(), 3)
+
+	_, err = ParseDimensions("nope")
+	assert.Error(t, err)
+	_, err = ParseMetrics("count,median")
+	assert.Error(t, err)
+	_, err = ParseMetrics("count,p101")
+	assert.Error(t, err)
+
+	metrics, err := ParseMetrics("count,p99.9")
+	assert.NoError(t, err)
+	p, ok := metrics[1].Percentile()
+	assert.True(t, ok)
+	assert.Equal(t, 99.9, p)
+
+	assert.Equal(t, []Metric{MetricCount, MetricSum, "p50", "p99.9", MetricMax}, DefaultMetrics([]float64{50, 99.9}))
+}
+
+func mustDimension(t *testing.T, spec string) Dimension {
+	dimension, err := ParseDimension(spec)
+	assert.NoError(t, err)
+	return dimension
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Budget is a set of rules an analysis must stay within, e.g. to fail a CI
+// build when captured DB behavior regresses. Every rule is optional.
+type Budget struct {
+	// MaxQueriesPerRequest limits the queries of a request, by the value of
+	// a tag such as controller. Requests are told apart by their request_id tag.
+	MaxQueriesPerRequest []QueriesPerRequestRule `json:"max_queries_per_request"`
+	// MaxLatency limits a percentile of the latency of a fingerprint
+	MaxLatency []LatencyRule `json:"max_latency"`
+	// MaxTransactionDuration limits the duration of every transaction
+	MaxTransactionDuration BudgetDuration `json:"max_transaction_duration"`
+	// MaxIdleGap limits every idle-in-transaction gap
+	MaxIdleGap BudgetDuration `json:"max_idle_gap"`
+	// AllowedFingerprints lists every fingerprint the capture may contain, if set
+	AllowedFingerprints []string `json:"allowed_fingerprints"`
+}
+
+type QueriesPerRequestRule struct {
+	// Tag is the tag key, and Value its value or "*" for every value
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+	Max   int    `json:"max"`
+}
+
+type LatencyRule struct {
+	// Fingerprint is the query fingerprint, or "*" for every fingerprint
+	Fingerprint string         `json:"fingerprint"`
+	Percentile  float64        `json:"percentile"`
+	Max         BudgetDuration `json:"max"`
+}
+
+// BudgetDuration is a duration written like "250ms" in a budget file
+type BudgetDuration struct {
+	time.Duration
+}
+
+func (d *BudgetDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func ReadBudget(path string) (Budget, error) {
+	var budget Budget
+
+	f, err := os.Open(path)
+	if err != nil {
+		return budget, err
+	}
+	defer f.Close()
+
+	// a misspelled rule would otherwise be silently skipped
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&budget); err != nil {
+		return budget, fmt.Errorf("%s: %w", path, err)
+	}
+	if budget.Rules() == 0 {
+		return budget, fmt.Errorf("%s: the budget has no rules", path)
+	}
+
+	for _, rule := range budget.MaxLatency {
+		if rule.Percentile <= 0 || rule.Percentile > 100 {
+			return budget, fmt.Errorf("%s: percentile %v of %q must be between 0 and 100", path, rule.Percentile, rule.Fingerprint)
+		}
+	}
+	for _, rule := range budget.MaxQueriesPerRequest {
+		if rule.Tag == "" {
+			return budget, fmt.Errorf("%s: max_queries_per_request needs a tag", path)
+		}
+	}
+
+	return budget, nil
+}
+
+// Violation is one rule the analysis broke
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Rule + ": " + v.Message
+}
+
+// Rules returns the number of rules of the budget
+func (b *Budget) Rules() int {
+	rules := len(b.MaxQueriesPerRequest) + len(b.MaxLatency)
+	if b.MaxTransactionDuration.Duration > 0 {
+		rules++
+	}
+	if b.MaxIdleGap.Duration > 0 {
+		rules++
+	}
+	if b.AllowedFingerprints != nil {
+		rules++
+	}
+	return rules
+}
+
+// Check evaluates the budget against the analysis and returns every violation
+// and the number of rules checked
+func (b *Budget) Check(config StatisticsConfig, frames Frames, transactions Transactions) ([]Violation, int) {
+	var violations []Violation
+	checked := 0
+
+	for _, rule := range b.MaxQueriesPerRequest {
+		checked++
+		violations = append(violations, rule.check(frames)...)
+	}
+
+	if len(b.MaxLatency) > 0 {
+		byFingerprint := NewGroupBy(config, mustParseDimensions("fingerprint"))
+		for _, frame := range frames {
+			byFingerprint.AddFrame(frame)
+		}
+
+		for _, rule := range b.MaxLatency {
+			checked++
+			for _, group := range byFingerprint.Groups() {
+				if rule.Fingerprint != "*" && rule.Fingerprint != group.Values[0] {
+					continue
+				}
+				if value := group.Durations.Quantile(rule.Percentile / 100); value > rule.Max.Duration {
+					violations = append(violations, Violation{
+						Rule:    "max_latency",
+						Message: fmt.Sprintf("%s of %q is %s over %d queries, budget %s", Percentile{Percentile: rule.Percentile}.Name(), group.Values[0], value, group.Durations.Count, rule.Max),
+					})
+				}
+			}
+		}
+	}
+
+	if b.MaxTransactionDuration.Duration > 0 || b.MaxIdleGap.Duration > 0 {
+		if b.MaxTransactionDuration.Duration > 0 {
+			checked++
+		}
+		if b.MaxIdleGap.Duration > 0 {
+			checked++
+		}
+
+		for _, t := range sortedTransactions(transactions) {
+			first := t.Frames[0]
+			if max := b.MaxTransactionDuration.Duration; max > 0 && t.TotalDuration() > max {
+				violations = append(violations, Violation{
+					Rule:    "max_transaction_duration",
+					Message: fmt.Sprintf("transaction on stream %d at frame %d took %s, budget %s: %s", first.TCPStream, first.Number, t.TotalDuration(), max, strings.Join(t.FingerprintSlice(true), "; ")),
+				})
+			}
+
+			if max := b.MaxIdleGap.Duration; max > 0 {
+				for _, gap := range t.Gaps() {
+					if gap.Duration > max {
+						violations = append(violations, Violation{
+							Rule:    "max_idle_gap",
+							Message: fmt.Sprintf("%s gap of %s on stream %d at frame %d, budget %s: %q then %q", gap.Kind, gap.Duration, first.TCPStream, gap.After.Number, max, gap.Before.MySQLQuery.Fingerprint, gap.After.MySQLQuery.Fingerprint),
+						})
+					}
+				}
+			}
+		}
+	}
+
+	if b.AllowedFingerprints != nil {
+		checked++
+		allowed := make(map[string]bool, len(b.AllowedFingerprints))
+		for _, fingerprint := range b.AllowedFingerprints {
+			allowed[fingerprint] = true
+		}
+
+		seen := make(map[string]bool)
+		for _, frame := range frames {
+			fingerprint := frame.MySQLQuery.Fingerprint
+			if fingerprint == "" || allowed[fingerprint] || seen[fingerprint] {
+				continue
+			}
+			seen[fingerprint] = true
+			violations = append(violations, Violation{
+				Rule:    "allowed_fingerprints",
+				Message: fmt.Sprintf("%q is not in the allowlist, first seen at frame %d: %s", fingerprint, frame.Number, frame.MySQLQuery.Query),
+			})
+		}
+	}
+
+	return violations, checked
+}
+
+// check counts the queries of each request with the tag of the rule
+func (rule *QueriesPerRequestRule) check(frames Frames) []Violation {
+	type request struct {
+		id      string
+		value   string
+		queries int
+	}
+
+	requests := make(map[string]*request)
+	var order []string
+	for _, frame := range frames {
+		query := frame.MySQLQuery
+		value, ok := query.Tags[rule.Tag]
+		if query.Fingerprint == "" || query.RequestID == "" || !ok {
+			continue
+		}
+		if rule.Value != "" && rule.Value != "*" && rule.Value != value {
+			continue
+		}
+
+		key := value + "\t" + query.RequestID
+		if _, ok := requests[key]; !ok {
+			requests[key] = &request{id: query.RequestID, value: value}
+			order = append(order, key)
+		}
+		requests[key].queries++
+	}
+
+	var violations []Violation
+	for _, key := range order {
+		r := requests[key]
+		if r.queries > rule.Max {
+			violations = append(violations, Violation{
+				Rule:    "max_queries_per_request",
+				Message: fmt.Sprintf("request %s with %s:%s made %d queries, budget %d", r.id, rule.Tag, r.value, r.queries, rule.Max),
+			})
+		}
+	}
+	return violations
+}
+
+// sortedTransactions returns the transactions in the order they started
+func sortedTransactions(transactions Transactions) []*Transaction {
+	result := make([]*Transaction, 0, len(transactions.Transactions))
+	for _, t := range transactions.Transactions {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Frames[0].TimeRelative < result[j].Frames[0].TimeRelative
+	})
+	return result
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+		"max_queries_per_request": [{"tag": "controller", "value": "*", "max": 2}],
+		"max_latency": [{"fingerprint": "select ? from foo", "percentile": 99, "max": "5ms"}],
+		"max_transaction_duration": "1s",
+		"max_idle_gap": "50ms",
+		"allowed_fingerprints": ["begin", "commit", "select ? from foo"]
+	}`), 0644))
+
+	budget, err := ReadBudget(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 50*time.Millisecond, budget.MaxIdleGap.Duration)
+
+	frames, transactions := buildTransactions(t, 1, [][]timedQuery{{
+		{"BEGIN", 0},
+		{"SELECT 1 FROM foo /*controller:bar,request_id:1*/", 10 * time.Millisecond},
+		{"SELECT 2 FROM foo /*controller:bar,request_id:1*/", 20 * time.Millisecond},
+		{"UPDATE foo SET a = 1 /*controller:bar,request_id:1*/", 30 * time.Millisecond},
+		// the commit waited
+		{"COMMIT", 200 * time.Millisecond},
+	}})
+
+	violations, checked := budget.Check(DefaultStatisticsConfig(), frames, transactions)
+	assert.Equal(t, 5, checked)
+
+	var rules []string
+	for _, violation := range violations {
+		rules = append(rules, violation.Rule)
+	}
+	assert.Equal(t, []string{"max_queries_per_request", "max_idle_gap", "allowed_fingerprints"}, rules)
+	assert.Contains(t, violations[0].String(), "request 1 with controller:bar made 3 queries, budget 2")
+
+	_, err = ReadBudget(path + ".missing")
+	assert.Error(t, err)
+
+	// a misspelled rule is an error rather than no rule
+	assert.NoError(t, os.WriteFile(path, []byte(`{"max_latencies": [{"fingerprint": "*", "percentile": 99, "max": "10ms"}]}`), 0644))
+	_, err = ReadBudget(path)
+	assert.ErrorContains(t, err, `unknown field "max_latencies"`)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{}`), 0644))
+	_, err = ReadBudget(path)
+	assert.EqualError(t, err, path+": the budget has no rules")
+}
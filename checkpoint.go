@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CheckpointVersion is the version of the checkpoint file format
+const CheckpointVersion = 2
+
+// Checkpoint is the state of an analysis part of the way through its input.
+// Every aggregation is computed from the parser state once the input is
+// exhausted, so the parser state and the input position are all there is
+// to save. The frames are appended to a log next to the checkpoint as they
+// are parsed, so a checkpoint only writes what changed since the last one.
+type Checkpoint struct {
+	Version int
+	// Files are the capture files that were completely processed, keyed by CaptureKey
+	Files []string
+	// File is the capture file being processed and Offset how many of its frames were
+	File   string
+	Offset int
+	Parser parserState
+	// FramesSize is the length of the frames log at the checkpoint, anything
+	// after it was written by a checkpoint that did not complete
+	FramesSize int64
+}
+
+// parserState is the serializable state of a FrameParser without its frames
+type parserState struct {
+	UnRespondedStreams     map[int]int
+	OpenTransactionStreams map[int]transactionId
+	Streams                map[string]int
+	FileStreams            map[int]int
+	StreamOffset           int
+	NextStream             int
+	StartTime              time.Time
+	TimeOffset             time.Duration
+	Files                  int
+	Quality                CaptureQuality
+}
+
+// framesChunk is what a checkpoint appends to the frames log
+type framesChunk struct {
+	Frames []frameRecord
+	// Deleted are the transactions dropped since the previous chunk
+	Deleted []int
+}
+
+// frameRecord is a frame that is new or was still waiting for its response at
+// the previous checkpoint. Frames are shared between FrameParser.Frames and
+// the transactions, so the frame refers to its transaction by id.
+type frameRecord struct {
+	Index int
+	Frame Frame
+	// RawQuery is the unexported MySQLQuery.rawQuery
+	RawQuery string
+	// Transaction is the id of the transaction of the frame, -1 if there is none
+	Transaction int
+}
+
+// Checkpointer periodically saves the state of an analysis to a file
+type Checkpointer struct {
+	path     string
+	interval time.Duration
+	last     time.Time
+	// files are the capture files that were completely processed
+	files []string
+	done  map[string]bool
+
+	// size is the length of the frames log and logged the number of frames in it
+	size   int64
+	logged int
+	// pending are the logged frames that were waiting for their response
+	pending []int
+	// transactions are the transactions with logged frames
+	transactions map[int]bool
+}
+
+func NewCheckpointer(path string, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		path:         path,
+		interval:     interval,
+		last:         time.Now(),
+		done:         make(map[string]bool),
+		transactions: make(map[int]bool),
+	}
+}
+
+func (c *Checkpointer) framesPath() string {
+	return c.path + ".frames"
+}
+
+// Resume restores the parser from the last checkpoint and returns it.
+// It returns false if there is no checkpoint yet
+func (c *Checkpointer) Resume(fp *FrameParser) (Checkpoint, bool, error) {
+	var checkpoint Checkpoint
+
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return checkpoint, false, nil
+	} else if err != nil {
+		return checkpoint, false, err
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(&checkpoint); err != nil {
+		return checkpoint, false, fmt.Errorf("%s: %w", c.path, err)
+	}
+	if checkpoint.Version != CheckpointVersion {
+		return checkpoint, false, fmt.Errorf("%s: unsupported checkpoint version %d", c.path, checkpoint.Version)
+	}
+
+	chunks, err := readFramesLog(c.framesPath(), checkpoint.FramesSize)
+	if err != nil {
+		return checkpoint, false, err
+	}
+
+	fp.restore(checkpoint.Parser, chunks)
+	c.files = checkpoint.Files
+	for _, file := range c.files {
+		c.done[file] = true
+	}
+	c.size = checkpoint.FramesSize
+	c.logged = len(fp.Frames)
+	c.pending = c.pending[:0]
+	for _, idx := range fp.unRespondedStreams {
+		c.pending = append(c.pending, idx)
+	}
+	for id := range fp.Transactions.Transactions {
+		c.transactions[id] = true
+	}
+
+	return checkpoint, true, nil
+}
+
+// Done returns whether the capture file was completely processed before the checkpoint
+func (c *Checkpointer) Done(key string) bool {
+	return c.done[key]
+}
+
+// FileDone records that the capture file was completely processed
+func (c *Checkpointer) FileDone(key string) {
+	if !c.done[key] {
+		c.files = append(c.files, key)
+		c.done[key] = true
+	}
+}
+
+// Due returns whether it is time for the next checkpoint
+func (c *Checkpointer) Due() bool {
+	return time.Since(c.last) >= c.interval
+}
+
+// Save writes a checkpoint of the parser, part of the way through file
+func (c *Checkpointer) Save(fp *FrameParser, file string, offset int) error {
+	size, err := c.appendFrames(fp)
+	if err != nil {
+		return err
+	}
+
+	checkpoint := Checkpoint{
+		Version:    CheckpointVersion,
+		Files:      c.files,
+		File:       file,
+		Offset:     offset,
+		Parser:     fp.state(),
+		FramesSize: size,
+	}
+
+	// write to a temporary file first so a crash never leaves a partial checkpoint
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(&checkpoint); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return err
+	}
+
+	c.size = size
+	c.logged = len(fp.Frames)
+	c.pending = c.pending[:0]
+	for _, idx := range fp.unRespondedStreams {
+		c.pending = append(c.pending, idx)
+	}
+	c.last = time.Now()
+	return nil
+}
+
+// appendFrames appends the frames parsed since the last checkpoint, and the
+// ones that were still waiting for their response then, to the frames log
+// and returns its new length
+func (c *Checkpointer) appendFrames(fp *FrameParser) (int64, error) {
+	var chunk framesChunk
+
+	indexes := make(map[*Frame]int)
+	for _, idx := range c.pending {
+		indexes[fp.Frames[idx]] = idx
+	}
+	for idx := c.logged; idx < len(fp.Frames); idx++ {
+		indexes[fp.Frames[idx]] = idx
+	}
+
+	// the frames of a transaction are in order, so its unlogged ones are at the end
+	transactions := make(map[int]int, len(indexes))
+	for id, transaction := range fp.Transactions.Transactions {
+		for i := len(transaction.Frames) - 1; i >= 0; i-- {
+			idx, ok := indexes[transaction.Frames[i]]
+			if !ok {
+				break
+			}
+			transactions[idx] = id
+			c.transactions[id] = true
+		}
+	}
+	for id := range c.transactions {
+		if _, ok := fp.Transactions.Transactions[id]; !ok {
+			chunk.Deleted = append(chunk.Deleted, id)
+			delete(c.transactions, id)
+		}
+	}
+	sort.Ints(chunk.Deleted)
+
+	for _, idx := range append(append([]int{}, c.pending...), indexRange(c.logged, len(fp.Frames))...) {
+		transaction, ok := transactions[idx]
+		if !ok {
+			transaction = -1
+		}
+		frame := fp.Frames[idx]
+		chunk.Frames = append(chunk.Frames, frameRecord{Index: idx, Frame: *frame, RawQuery: frame.MySQLQuery.rawQuery, Transaction: transaction})
+	}
+
+	f, err := os.OpenFile(c.framesPath(), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// drop whatever an incomplete checkpoint appended after the last one,
+	// or the log of a previous analysis if nothing was logged yet
+	if err := f.Truncate(c.size); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&chunk); err != nil {
+		return 0, err
+	}
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(buf.Len()))
+	if _, err := f.WriteAt(append(length[:], buf.Bytes()...), c.size); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+
+	return c.size + int64(len(length)+buf.Len()), nil
+}
+
+// readFramesLog reads the chunks of the frames log up to size
+func readFramesLog(path string, size int64) ([]framesChunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var chunks []framesChunk
+	r := bufio.NewReader(io.LimitReader(f, size))
+	for {
+		var length [8]byte
+		if _, err := io.ReadFull(r, length[:]); err == io.EOF {
+			return chunks, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		var chunk framesChunk
+		if err := gob.NewDecoder(io.LimitReader(r, int64(binary.BigEndian.Uint64(length[:])))).Decode(&chunk); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func indexRange(from int, to int) []int {
+	result := make([]int, 0, to-from)
+	for idx := from; idx < to; idx++ {
+		result = append(result, idx)
+	}
+	return result
+}
+
+func (fp *FrameParser) state() parserState {
+	state := parserState{
+		UnRespondedStreams:     fp.unRespondedStreams,
+		OpenTransactionStreams: make(map[int]transactionId, len(fp.openTransactionStreams)),
+		Streams:                fp.streams,
+		FileStreams:            fp.fileStreams,
+		StreamOffset:           fp.streamOffset,
+		NextStream:             fp.nextStream,
+		StartTime:              fp.startTime,
+		TimeOffset:             fp.timeOffset,
+		Files:                  fp.files,
+		Quality:                fp.Quality,
+	}
+
+	for stream, txid := range fp.openTransactionStreams {
+		state.OpenTransactionStreams[stream] = *txid
+	}
+
+	return state
+}
+
+func (fp *FrameParser) restore(state parserState, chunks []framesChunk) {
+	*fp = NewFrameParser()
+
+	// later records of a frame replace earlier ones
+	var records []frameRecord
+	deleted := make(map[int]bool)
+	for _, chunk := range chunks {
+		for _, record := range chunk.Frames {
+			if record.Index >= len(records) {
+				records = append(records, make([]frameRecord, record.Index-len(records)+1)...)
+			}
+			records[record.Index] = record
+		}
+		for _, id := range chunk.Deleted {
+			deleted[id] = true
+		}
+	}
+
+	fp.Frames = make(Frames, len(records))
+	for idx := range records {
+		fp.Frames[idx] = &records[idx].Frame
+		fp.Frames[idx].MySQLQuery.rawQuery = records[idx].RawQuery
+	}
+
+	for idx, record := range records {
+		id := record.Transaction
+		if id < 0 || deleted[id] {
+			continue
+		}
+		if _, ok := fp.Transactions.Transactions[id]; !ok {
+			transaction := NewTransaction(id)
+			fp.Transactions.Add(&transaction)
+		}
+		fp.Transactions.Transactions[id].AddFrame(fp.Frames[idx])
+	}
+
+	for stream, txid := range state.OpenTransactionStreams {
+		txid := txid
+		fp.openTransactionStreams[stream] = &txid
+	}
+
+	// gob decodes empty maps as nil
+	for stream, idx := range state.UnRespondedStreams {
+		fp.unRespondedStreams[stream] = idx
+	}
+	for key, stream := range state.Streams {
+		fp.streams[key] = stream
+	}
+	for fileStream, stream := range state.FileStreams {
+		fp.fileStreams[fileStream] = stream
+	}
+
+	fp.streamOffset = state.StreamOffset
+	fp.nextStream = state.NextStream
+	fp.startTime = state.StartTime
+	fp.timeOffset = state.TimeOffset
+	fp.files = state.Files
+	fp.Quality = state.Quality
+}
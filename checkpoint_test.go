@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	var rawframes []rawframe
+	for i, query := range []string{"BEGIN", "", "SELECT 1", "", "COMMIT", ""} {
+		layers := map[string]string{
+			"frame.number":        strconv.Itoa(i + 1),
+			"tcp.stream":          "0",
+			"frame.time_relative": strconv.FormatFloat(float64(i)*0.01, 'f', 3, 64),
+		}
+		if query == "" {
+			layers["mysql.response_code"] = "0"
+		} else {
+			layers["mysql.query"] = query
+		}
+		rawframes = append(rawframes, rawFrame(layers))
+	}
+
+	uninterrupted := NewFrameParser()
+	assert.NoError(t, uninterrupted.ParseRawFrames(rawframes))
+
+	// checkpoint twice while the SELECT and then the COMMIT wait for their
+	// response, and stop in the middle of the transaction
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	checkpointer := NewCheckpointer(path, time.Minute)
+	fp := NewFrameParser()
+	fp.StartFile()
+	for i, rawframe := range rawframes[:5] {
+		assert.NoError(t, fp.AddRawFrame(rawframe))
+		if i == 2 || i == 4 {
+			assert.NoError(t, checkpointer.Save(&fp, "capture.json", i+1))
+		}
+	}
+
+	// the second checkpoint only logs the new frames and the answered SELECT
+	chunks, err := readFramesLog(checkpointer.framesPath(), checkpointer.size)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 2)
+	assert.Len(t, chunks[0].Frames, 3)
+	assert.Len(t, chunks[1].Frames, 3)
+	assert.Equal(t, 2, chunks[1].Frames[0].Index)
+
+	resumed := NewFrameParser()
+	checkpoint, ok, err := NewCheckpointer(path, time.Minute).Resume(&resumed)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "capture.json", checkpoint.File)
+	assert.Equal(t, 5, checkpoint.Offset)
+
+	for _, rawframe := range rawframes[checkpoint.Offset:] {
+		assert.NoError(t, resumed.AddRawFrame(rawframe))
+	}
+	resumed.Finish()
+
+	assert.Equal(t, uninterrupted.Frames, resumed.Frames)
+	assert.Len(t, resumed.Transactions.Transactions, 1)
+	assert.Equal(t, uninterrupted.Transactions.Transactions[0].Frames, resumed.Transactions.Transactions[0].Frames)
+	assert.Equal(t, uninterrupted.Transactions.Transactions[0].TotalDuration(), resumed.Transactions.Transactions[0].TotalDuration())
+}
+
+func TestCheckpointerDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mysql.json")
+	assert.NoError(t, os.WriteFile(path, []byte("[]"), 0644))
+	key, err := CaptureKey(path)
+	assert.NoError(t, err)
+
+	checkpointer := NewCheckpointer(filepath.Join(t.TempDir(), "checkpoint"), time.Minute)
+	checkpointer.FileDone(key)
+	assert.True(t, checkpointer.Done(key))
+
+	// a ring buffer rewrote the file
+	assert.NoError(t, os.WriteFile(path, []byte("[ ]"), 0644))
+	rewritten, err := CaptureKey(path)
+	assert.NoError(t, err)
+	assert.False(t, checkpointer.Done(rewritten))
+}
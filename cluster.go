@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// DefaultSimilarity is how similar two transaction fingerprints must be to cluster
+const DefaultSimilarity = 0.75
+
+// Cluster is a group of normalized transactions with similar fingerprints,
+// usually one code path with optional statements
+type Cluster struct {
+	// Skeleton is the statements every member has, in order
+	Skeleton []string
+	Members  []*NormalizedTransaction
+	// Merged holds the statistics of all members
+	Merged *NormalizedTransaction
+}
+
+// Clusters groups normalized transactions whose fingerprints are at least
+// similarity alike
+type Clusters struct {
+	config     StatisticsConfig
+	similarity float64
+	Clusters   []*Cluster
+}
+
+// NewClusters clusters the normalized transactions. The most frequent
+// transaction of each cluster is its leader, and every other transaction
+// joins the first cluster whose leader is similar enough.
+func NewClusters(config StatisticsConfig, similarity float64, nts *NormalizedTransactions) (Clusters, error) {
+	clusters := Clusters{config: config, similarity: similarity}
+
+	ordered := make([]*NormalizedTransaction, 0, len(nts.Transactions))
+	for _, nt := range nts.Transactions {
+		ordered = append(ordered, nt)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].transactionDurations.Count != ordered[j].transactionDurations.Count {
+			return ordered[i].transactionDurations.Count > ordered[j].transactionDurations.Count
+		}
+		return strings.Join(ordered[i].Fingerprint, "\n") < strings.Join(ordered[j].Fingerprint, "\n")
+	})
+
+	for _, nt := range ordered {
+		var cluster *Cluster
+		for _, c := range clusters.Clusters {
+			if Similarity(c.Members[0].Fingerprint, nt.Fingerprint) >= similarity {
+				cluster = c
+				break
+			}
+		}
+
+		if cluster == nil {
+			merged := NewNormalizedTransaction(config)
+			cluster = &Cluster{Skeleton: nt.Fingerprint, Merged: &merged}
+			clusters.Clusters = append(clusters.Clusters, cluster)
+		}
+
+		cluster.Members = append(cluster.Members, nt)
+		cluster.Skeleton = longestCommonSubsequence(cluster.Skeleton, nt.Fingerprint)
+		if err := cluster.Merged.Merge(nt); err != nil {
+			return clusters, err
+		}
+	}
+
+	// the clusters holding the most transaction time first
+	sort.SliceStable(clusters.Clusters, func(i, j int) bool {
+		return clusters.Clusters[i].Merged.transactionDurations.Sum > clusters.Clusters[j].Merged.transactionDurations.Sum
+	})
+
+	return clusters, nil
+}
+
+// Similarity returns 1 minus the edit distance between two statement
+// sequences relative to the longer one, 1 for identical sequences
+func Similarity(a []string, b []string) float64 {
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(a, b))/float64(longest)
+}
+
+// editDistance is the Levenshtein distance between two statement sequences
+func editDistance(a []string, b []string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func longestCommonSubsequence(a []string, b []string) []string {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	result := []string{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, a[i])
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return result
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (cs *Clusters) MarshalJSON() ([]byte, error) {
+	type member struct {
+		ID           string   `json:"id"`
+		Fingerprint  []string `json:"fingerprint"`
+		Transactions int      `json:"transactions"`
+	}
+	type cluster struct {
+		ID                    string         `json:"id"`
+		Skeleton              []string       `json:"skeleton"`
+		Transactions          int            `json:"transactions"`
+		Members               []member       `json:"members"`
+		Tags                  []string       `json:"tags"`
+		QueryStatistics       TimeStatistics `json:"query_statistics"`
+		TransactionStatistics TimeStatistics `json:"transaction_statistics"`
+		WasteStatistics       TimeStatistics `json:"waste_statistics"`
+	}
+
+	result := []cluster{}
+	for _, c := range cs.Clusters {
+		entry := cluster{
+			ID:           TransactionID(c.Skeleton),
+			Skeleton:     c.Skeleton,
+			Transactions: c.Merged.transactionDurations.Count,
+		}
+		for _, nt := range c.Members {
+			entry.Members = append(entry.Members, member{ID: nt.ID(), Fingerprint: nt.Fingerprint, Transactions: nt.transactionDurations.Count})
+		}
+		for tag := range c.Merged.tags {
+			entry.Tags = append(entry.Tags, tag)
+		}
+		sort.Strings(entry.Tags)
+
+		var err error
+		if entry.QueryStatistics, err = NewTimeStatistics(c.Merged.queryDurations, cs.config.Percentiles); err != nil {
+			return nil, err
+		}
+		if entry.TransactionStatistics, err = NewTimeStatistics(c.Merged.transactionDurations, cs.config.Percentiles); err != nil {
+			return nil, err
+		}
+		if entry.WasteStatistics, err = NewTimeStatistics(c.Merged.wasteDurations, cs.config.Percentiles); err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+
+	return json.Marshal(struct {
+		Similarity float64   `json:"similarity"`
+		Clusters   []cluster `json:"clusters"`
+	}{cs.similarity, result})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusters(t *testing.T) {
+	assert.Equal(t, 1.0, Similarity([]string{"a", "b"}, []string{"a", "b"}))
+	assert.Equal(t, 0.75, Similarity([]string{"a", "b", "c", "d"}, []string{"a", "b", "d"}))
+	assert.Equal(t, []string{"a", "c"}, longestCommonSubsequence([]string{"a", "b", "c"}, []string{"a", "c", "d"}))
+
+	_, transactions := buildTransactions(t, 1, [][]timedQuery{
+		{{"BEGIN", 0}, {"SELECT 1 FROM foo", time.Millisecond}, {"UPDATE foo SET a = 1", 2 * time.Millisecond}, {"COMMIT", 3 * time.Millisecond}},
+		{{"BEGIN", 0}, {"SELECT 1 FROM foo", time.Millisecond}, {"UPDATE foo SET a = 1", 2 * time.Millisecond}, {"COMMIT", 3 * time.Millisecond}},
+		{{"BEGIN", 0}, {"SELECT 1 FROM foo", time.Millisecond}, {"INSERT INTO audit VALUES (1)", 2 * time.Millisecond}, {"UPDATE foo SET a = 1", 3 * time.Millisecond}, {"COMMIT", 4 * time.Millisecond}},
+		{{"BEGIN", 0}, {"DELETE FROM bar", time.Millisecond}, {"COMMIT", 2 * time.Millisecond}},
+	})
+	nts := NewNormalizedTransactions(DefaultStatisticsConfig())
+	for id := 0; id < len(transactions.Transactions); id++ {
+		nts.Add(*transactions.Transactions[id])
+	}
+
+	clusters, err := NewClusters(DefaultStatisticsConfig(), 0.75, &nts)
+	assert.NoError(t, err)
+	assert.Len(t, clusters.Clusters, 2)
+
+	cluster := clusters.Clusters[0]
+	assert.Len(t, cluster.Members, 2)
+	assert.Equal(t, []string{"begin", "select ? from foo", "update foo set a = ?", "commit"}, cluster.Skeleton)
+	assert.Equal(t, 3, cluster.Merged.transactionDurations.Count)
+	assert.Equal(t, 1, clusters.Clusters[1].Merged.transactionDurations.Count)
+}
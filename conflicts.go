@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the MySQL errors of lock contention
+const (
+	ErrLockWaitTimeout = 1205
+	ErrLockDeadlock    = 1213
+)
+
+// rowKeyPattern matches a column compared to a number or string literal
+var rowKeyPattern = regexp.MustCompile(`(?i)([a-z_][a-z0-9_$.]*)\s*=\s*('(?:[^'\\]|\\.)*'|-?[0-9]+)`)
+
+// Conflict is two transactions on different streams holding the locks of the
+// same table at the same time
+type Conflict struct {
+	Table   string
+	A       *Transaction
+	B       *Transaction
+	Overlap time.Duration
+	// Keys are the row keys both transactions wrote, e.g. id=123, when they
+	// likely wrote the same row
+	Keys []string
+}
+
+// HotSpot is the contention on a table, or on one key of a table
+type HotSpot struct {
+	Table string
+	Key   string
+	// Conflicts and Overlap are the conflicts involving the table or key
+	Conflicts int
+	Overlap   time.Duration
+	// Deadlocks and LockWaits count the lock errors on the table or key
+	Deadlocks int
+	LockWaits int
+}
+
+// LockError is a deadlock or lock wait timeout reported by the server
+type LockError struct {
+	Frame  *Frame
+	Tables []string
+	Keys   []string
+	// Conflicts are the conflicts of the transaction the error happened in
+	Conflicts int
+}
+
+// ConflictReport finds the transactions that contended for the same tables
+// and rows
+type ConflictReport struct {
+	config    StatisticsConfig
+	top       int
+	Conflicts []*Conflict
+	Errors    []*LockError
+	tables    map[string]*HotSpot
+	keys      map[string]*HotSpot
+}
+
+// NewConflictReport finds the overlapping lock holds of transactions on
+// different streams and links them to the lock errors in the capture,
+// reporting the top entries of each list
+func NewConflictReport(config StatisticsConfig, top int, frames Frames, transactions Transactions) ConflictReport {
+	report := ConflictReport{
+		config: config,
+		top:    top,
+		tables: make(map[string]*HotSpot),
+		keys:   make(map[string]*HotSpot),
+	}
+
+	statements := make(StatementCache)
+	byTable := make(map[string][]lockHold)
+	keys := make(map[*Transaction]map[string][]string)
+	for _, t := range sortedTransactions(transactions) {
+		keys[t] = make(map[string][]string)
+		for _, hold := range lockHolds(t, statements) {
+			byTable[hold.Table] = append(byTable[hold.Table], hold)
+		}
+		for _, frame := range t.Frames {
+			statement := statements.Parse(frame.MySQLQuery.Fingerprint)
+			key := rowKey(frame.MySQLQuery.Query)
+			if key == "" {
+				continue
+			}
+			for _, table := range statement.Writes {
+				keys[t][table] = append(keys[t][table], key)
+			}
+		}
+	}
+
+	// sweep over the holds of each table in the order they started
+	tables := make([]string, 0, len(byTable))
+	for table := range byTable {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	involved := make(map[*Transaction]int)
+	for _, table := range tables {
+		holds := byTable[table]
+		sort.SliceStable(holds, func(i, j int) bool {
+			return holds[i].Interval.Start < holds[j].Interval.Start
+		})
+
+		var active []lockHold
+		for _, hold := range holds {
+			var still []lockHold
+			for _, other := range active {
+				if other.Interval.End <= hold.Interval.Start {
+					continue
+				}
+				still = append(still, other)
+				if other.Transaction.Frames[0].TCPStream == hold.Transaction.Frames[0].TCPStream {
+					continue
+				}
+
+				end := hold.Interval.End
+				if other.Interval.End < end {
+					end = other.Interval.End
+				}
+				conflict := &Conflict{
+					Table:   table,
+					A:       other.Transaction,
+					B:       hold.Transaction,
+					Overlap: end - hold.Interval.Start,
+					Keys:    intersectStrings(keys[other.Transaction][table], keys[hold.Transaction][table]),
+				}
+				report.Conflicts = append(report.Conflicts, conflict)
+				involved[conflict.A]++
+				involved[conflict.B]++
+
+				spot := report.spot(table, "")
+				spot.Conflicts++
+				spot.Overlap += conflict.Overlap
+				for _, key := range conflict.Keys {
+					spot := report.spot(table, key)
+					spot.Conflicts++
+					spot.Overlap += conflict.Overlap
+				}
+			}
+			active = append(still, hold)
+		}
+	}
+
+	inTransaction := make(map[*Frame]*Transaction)
+	for _, t := range transactions.Transactions {
+		for _, frame := range t.Frames {
+			inTransaction[frame] = t
+		}
+	}
+	for _, frame := range frames {
+		code := frame.MySQLQuery.ErrorCode
+		if code != ErrLockDeadlock && code != ErrLockWaitTimeout {
+			continue
+		}
+
+		statement := statements.Parse(frame.MySQLQuery.Fingerprint)
+		lockError := &LockError{
+			Frame:  frame,
+			Tables: statement.Tables(),
+		}
+		if key := rowKey(frame.MySQLQuery.Query); key != "" {
+			lockError.Keys = []string{key}
+		}
+		if t, ok := inTransaction[frame]; ok {
+			lockError.Conflicts = involved[t]
+		}
+		report.Errors = append(report.Errors, lockError)
+
+		for _, table := range lockError.Tables {
+			spots := []*HotSpot{report.spot(table, "")}
+			for _, key := range lockError.Keys {
+				spots = append(spots, report.spot(table, key))
+			}
+			for _, spot := range spots {
+				if code == ErrLockDeadlock {
+					spot.Deadlocks++
+				} else {
+					spot.LockWaits++
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(report.Conflicts, func(i, j int) bool {
+		return report.Conflicts[i].Overlap > report.Conflicts[j].Overlap
+	})
+
+	return report
+}
+
+func (r *ConflictReport) spot(table string, key string) *HotSpot {
+	spots := r.tables
+	if key != "" {
+		spots = r.keys
+	}
+	if _, ok := spots[table+"\t"+key]; !ok {
+		spots[table+"\t"+key] = &HotSpot{Table: table, Key: key}
+	}
+	return spots[table+"\t"+key]
+}
+
+// HotTables returns the tables ranked by conflict overlap, then lock errors
+func (r *ConflictReport) HotTables() []*HotSpot {
+	return rankHotSpots(r.tables)
+}
+
+// HotKeys returns the keys ranked by conflict overlap, then lock errors
+func (r *ConflictReport) HotKeys() []*HotSpot {
+	return rankHotSpots(r.keys)
+}
+
+func rankHotSpots(spots map[string]*HotSpot) []*HotSpot {
+	result := make([]*HotSpot, 0, len(spots))
+	for _, spot := range spots {
+		result = append(result, spot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Overlap != b.Overlap {
+			return a.Overlap > b.Overlap
+		}
+		if a.Deadlocks+a.LockWaits != b.Deadlocks+b.LockWaits {
+			return a.Deadlocks+a.LockWaits > b.Deadlocks+b.LockWaits
+		}
+		return a.Table+"\t"+a.Key < b.Table+"\t"+b.Key
+	})
+	return result
+}
+
+// rowKey returns the columns compared to literals in the WHERE clause of a
+// query, e.g. id=123, without table qualifiers, sorted and joined with " and ".
+// Two statements with the same row key likely wrote the same rows. The whole
+// set of equality predicates is compared, as a single shared predicate like
+// status='pending' or tenant_id=5 matches many rows. It is "" if there are none.
+func rowKey(query string) string {
+	idx := strings.Index(strings.ToLower(query), " where ")
+	if idx < 0 {
+		return ""
+	}
+
+	predicates := make(map[string]bool)
+	for _, match := range rowKeyPattern.FindAllStringSubmatch(query[idx:], -1) {
+		column := strings.ToLower(match[1])
+		if dot := strings.LastIndex(column, "."); dot >= 0 {
+			column = column[dot+1:]
+		}
+		predicates[column+"="+match[2]] = true
+	}
+	return strings.Join(sortedKeys(predicates), " and ")
+}
+
+// intersectStrings returns the sorted strings in both a and b
+func intersectStrings(a []string, b []string) []string {
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+	both := make(map[string]bool)
+	for _, s := range b {
+		if inA[s] {
+			both[s] = true
+		}
+	}
+	if len(both) == 0 {
+		return nil
+	}
+	return sortedKeys(both)
+}
+
+func (r *ConflictReport) MarshalJSON() ([]byte, error) {
+	type spot struct {
+		Table     string  `json:"table"`
+		Key       string  `json:"key,omitempty"`
+		Conflicts int     `json:"conflicts"`
+		Overlap   float64 `json:"overlap"`
+		Deadlocks int     `json:"deadlocks"`
+		LockWaits int     `json:"lock_wait_timeouts"`
+	}
+	type side struct {
+		Stream      int     `json:"stream"`
+		Frame       int     `json:"frame"`
+		ID          string  `json:"id"`
+		Duration    float64 `json:"duration"`
+		Fingerprint string  `json:"fingerprint"`
+	}
+	type conflict struct {
+		Table   string   `json:"table"`
+		Overlap float64  `json:"overlap"`
+		Keys    []string `json:"same_row_keys,omitempty"`
+		A       side     `json:"a"`
+		B       side     `json:"b"`
+	}
+	type lockError struct {
+		Frame       int      `json:"frame"`
+		Stream      int      `json:"stream"`
+		Code        int      `json:"code"`
+		Message     string   `json:"message"`
+		Fingerprint string   `json:"fingerprint"`
+		Tables      []string `json:"tables"`
+		Keys        []string `json:"keys,omitempty"`
+		Conflicts   int      `json:"conflicts"`
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	spots := func(hot []*HotSpot) []spot {
+		result := []spot{}
+		for idx, s := range hot {
+			if idx == r.top {
+				break
+			}
+			result = append(result, spot{s.Table, s.Key, s.Conflicts, ms(s.Overlap), s.Deadlocks, s.LockWaits})
+		}
+		return result
+	}
+	newSide := func(t *Transaction) side {
+		fingerprint := t.FingerprintWith(r.config.FingerprintStrategy)
+		return side{
+			Stream:      t.Frames[0].TCPStream,
+			Frame:       t.Frames[0].Number,
+			ID:          TransactionID(fingerprint),
+			Duration:    ms(t.TotalDuration()),
+			Fingerprint: strings.Join(fingerprint, "; "),
+		}
+	}
+
+	data := struct {
+		HotTables []spot      `json:"hot_tables"`
+		HotKeys   []spot      `json:"hot_keys"`
+		Conflicts []conflict  `json:"conflicts"`
+		Errors    []lockError `json:"lock_errors"`
+	}{
+		HotTables: spots(r.HotTables()),
+		HotKeys:   spots(r.HotKeys()),
+		Conflicts: []conflict{},
+		Errors:    []lockError{},
+	}
+
+	for idx, c := range r.Conflicts {
+		if idx == r.top {
+			break
+		}
+		data.Conflicts = append(data.Conflicts, conflict{c.Table, ms(c.Overlap), c.Keys, newSide(c.A), newSide(c.B)})
+	}
+	for _, e := range r.Errors {
+		query := e.Frame.MySQLQuery
+		data.Errors = append(data.Errors, lockError{e.Frame.Number, e.Frame.TCPStream, query.ErrorCode, query.Error, query.Fingerprint, e.Tables, e.Keys, e.Conflicts})
+	}
+
+	return json.Marshal(data)
+}
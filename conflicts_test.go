@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictReport(t *testing.T) {
+	frames, transactions := buildTransactions(t, 1, [][]timedQuery{
+		{
+			{"BEGIN", 0},
+			{"UPDATE users SET name = 'a' WHERE id = 1", 10 * time.Millisecond},
+			{"COMMIT", 100 * time.Millisecond},
+		},
+		{
+			{"BEGIN", 20 * time.Millisecond},
+			{"UPDATE users SET name = 'b' WHERE users.id = 1", 30 * time.Millisecond},
+			{"INSERT INTO audit VALUES (1)", 40 * time.Millisecond},
+			{"COMMIT", 150 * time.Millisecond},
+		},
+		{
+			{"BEGIN", 200 * time.Millisecond},
+			{"UPDATE users SET name = 'c' WHERE id = 2", 210 * time.Millisecond},
+			{"COMMIT", 220 * time.Millisecond},
+		},
+	})
+	frames[4].MySQLQuery.ErrorCode = ErrLockDeadlock
+
+	report := NewConflictReport(DefaultStatisticsConfig(), 10, frames, transactions)
+
+	assert.Len(t, report.Conflicts, 1)
+	conflict := report.Conflicts[0]
+	assert.Equal(t, "users", conflict.Table)
+	assert.Equal(t, 1, conflict.A.Frames[0].TCPStream)
+	assert.Equal(t, 2, conflict.B.Frames[0].TCPStream)
+	assert.Equal(t, 71*time.Millisecond, conflict.Overlap)
+	assert.Equal(t, []string{"id=1"}, conflict.Keys)
+
+	tables := report.HotTables()
+	assert.Len(t, tables, 1)
+	assert.Equal(t, "users", tables[0].Table)
+	assert.Equal(t, 1, tables[0].Conflicts)
+	assert.Equal(t, 1, tables[0].Deadlocks)
+
+	keys := report.HotKeys()
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "id=1", keys[0].Key)
+	assert.Equal(t, 71*time.Millisecond, keys[0].Overlap)
+	assert.Equal(t, 1, keys[0].Deadlocks)
+
+	assert.Len(t, report.Errors, 1)
+	assert.Equal(t, 1, report.Errors[0].Conflicts)
+
+	assert.Equal(t, "id=5 and name='x'", rowKey("DELETE FROM t WHERE t.id = 5 AND name = 'x'"))
+	assert.Equal(t, "", rowKey("INSERT INTO t VALUES (1)"))
+}
+
+func TestConflictReportSharedPredicate(t *testing.T) {
+	// both transactions write pending jobs, but different ones
+	frames, transactions := buildTransactions(t, 1, [][]timedQuery{
+		{
+			{"BEGIN", 0},
+			{"UPDATE jobs SET state = 'running' WHERE id = 1 AND state = 'pending'", 10 * time.Millisecond},
+			{"COMMIT", 100 * time.Millisecond},
+		},
+		{
+			{"BEGIN", 20 * time.Millisecond},
+			{"UPDATE jobs SET state = 'running' WHERE id = 2 AND state = 'pending'", 30 * time.Millisecond},
+			{"COMMIT", 150 * time.Millisecond},
+		},
+	})
+
+	report := NewConflictReport(DefaultStatisticsConfig(), 10, frames, transactions)
+	assert.Len(t, report.Conflicts, 1)
+	assert.Empty(t, report.Conflicts[0].Keys)
+	assert.Empty(t, report.HotKeys())
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// ConnectionReport describes the lifecycle of every connection of a capture
+// and rolls them up into distributions and connect/disconnect rates
+type ConnectionReport struct {
+	config      StatisticsConfig
+	Connections []Connection
+	// ClosedBy counts the connections by how they were closed
+	ClosedBy map[string]int
+	// AlreadyOpen counts the connections that were open when the capture started
+	AlreadyOpen int
+	// distributions over all connections
+	lifetimes   *Histogram
+	busy        *Histogram
+	idle        *Histogram
+	longestIdle *Histogram
+	queries     []int
+	rates       ConcurrencySeries
+}
+
+// Connection is the lifecycle of one TCP stream
+type Connection struct {
+	Stream int
+	Client string
+	Opened time.Duration
+	// AlreadyOpen is set if the connection was open when the capture started,
+	// so Opened is when it was first seen
+	AlreadyOpen bool
+	Closed      time.Duration
+	ClosedBy    string
+	Queries     int
+	Busy        time.Duration
+	Idle        time.Duration
+	LongestIdle time.Duration
+}
+
+// Lifetime returns how long the connection was open within the capture
+func (c *Connection) Lifetime() time.Duration {
+	return c.Closed - c.Opened
+}
+
+// NewConnectionReport builds the connection report of the capture. serverPort
+// tells the client and the server apart for connections without queries.
+func NewConnectionReport(config StatisticsConfig, frames Frames, transactions Transactions, serverPort int) ConnectionReport {
+	report := ConnectionReport{
+		config:      config,
+		ClosedBy:    make(map[string]int),
+		lifetimes:   NewHistogram(config.RelativeError),
+		busy:        NewHistogram(config.RelativeError),
+		idle:        NewHistogram(config.RelativeError),
+		longestIdle: NewHistogram(config.RelativeError),
+	}
+
+	streams := NewStreams(serverPort)
+	for _, frame := range frames {
+		streams.AddFrame(frame)
+	}
+
+	// the timeline was already checked for oddities by whoever wants to see them
+	timeline := NewTimeline(frames, transactions, io.Discard)
+	dbs := NewDurationBuckets(time.Second)
+	report.rates = dbs.Series(timeline, nil)
+	// only the connections opened during the capture are connects
+	for idx := range report.rates.Buckets {
+		report.rates.Buckets[idx].New = 0
+	}
+
+	for _, stream := range streams.Streams() {
+		interval := stream.Interval(timeline.End)
+		busy := stream.Busy()
+
+		c := Connection{
+			Stream:      stream.ID,
+			Client:      stream.Client,
+			Opened:      interval.Start,
+			AlreadyOpen: !stream.Started,
+			Closed:      interval.End,
+			ClosedBy:    streams.ClosedBy(stream),
+			Queries:     len(stream.Queries),
+			Busy:        busy,
+			Idle:        interval.End - interval.Start - busy,
+			LongestIdle: stream.LongestIdle(timeline.End),
+		}
+		// overlapping queries, e.g. pipelined ones, can add up to more than the lifetime
+		if c.Idle < 0 {
+			c.Idle = 0
+		}
+
+		report.Connections = append(report.Connections, c)
+		report.ClosedBy[c.ClosedBy]++
+		if c.AlreadyOpen {
+			report.AlreadyOpen++
+		} else {
+			report.rates.Buckets[dbs.bucket(c.Opened)].New++
+		}
+		report.lifetimes.Add(c.Lifetime())
+		report.busy.Add(c.Busy)
+		report.idle.Add(c.Idle)
+		report.longestIdle.Add(c.LongestIdle)
+		report.queries = append(report.queries, c.Queries)
+	}
+
+	return report
+}
+
+// QueryPercentile returns the percentile (0-100) of queries per connection
+func (cr *ConnectionReport) QueryPercentile(p float64) int {
+	if len(cr.queries) == 0 {
+		return 0
+	}
+
+	sorted := make([]int, len(cr.queries))
+	copy(sorted, cr.queries)
+	sort.Ints(sorted)
+
+	// nearest rank
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func (c *Connection) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+
+	return json.Marshal(struct {
+		Stream      int     `json:"stream"`
+		Client      string  `json:"client,omitempty"`
+		Opened      float64 `json:"opened"`
+		AlreadyOpen bool    `json:"already_open"`
+		Closed      float64 `json:"closed"`
+		ClosedBy    string  `json:"closed_by"`
+		Lifetime    float64 `json:"lifetime"`
+		Queries     int     `json:"queries"`
+		Busy        float64 `json:"busy"`
+		Idle        float64 `json:"idle"`
+		LongestIdle float64 `json:"longest_idle"`
+	}{
+		Stream:      c.Stream,
+		Client:      c.Client,
+		Opened:      ms(c.Opened),
+		AlreadyOpen: c.AlreadyOpen,
+		Closed:      ms(c.Closed),
+		ClosedBy:    c.ClosedBy,
+		Lifetime:    ms(c.Lifetime()),
+		Queries:     c.Queries,
+		Busy:        ms(c.Busy),
+		Idle:        ms(c.Idle),
+		LongestIdle: ms(c.LongestIdle),
+	})
+}
+
+func (cr *ConnectionReport) MarshalJSON() ([]byte, error) {
+	type rate struct {
+		Second      int `json:"second"`
+		Connects    int `json:"connects"`
+		Disconnects int `json:"disconnects"`
+	}
+
+	type queries struct {
+		Min         int            `json:"min"`
+		Mean        float64        `json:"mean"`
+		Percentiles map[string]int `json:"percentiles"`
+		Max         int            `json:"max"`
+		Sum         int            `json:"sum"`
+	}
+
+	data := struct {
+		Connections []*Connection   `json:"connections"`
+		ClosedBy    map[string]int  `json:"closed_by"`
+		AlreadyOpen int             `json:"already_open"`
+		Lifetime    *TimeStatistics `json:"lifetime,omitempty"`
+		Busy        *TimeStatistics `json:"busy,omitempty"`
+		Idle        *TimeStatistics `json:"idle,omitempty"`
+		LongestIdle *TimeStatistics `json:"longest_idle,omitempty"`
+		Queries     *queries        `json:"queries_per_connection,omitempty"`
+		Rates       []rate          `json:"rates"`
+	}{
+		ClosedBy:    cr.ClosedBy,
+		AlreadyOpen: cr.AlreadyOpen,
+	}
+
+	for idx := range cr.Connections {
+		data.Connections = append(data.Connections, &cr.Connections[idx])
+	}
+
+	if len(cr.Connections) > 0 {
+		for _, stat := range []struct {
+			h    *Histogram
+			dest **TimeStatistics
+		}{
+			{cr.lifetimes, &data.Lifetime},
+			{cr.busy, &data.Busy},
+			{cr.idle, &data.Idle},
+			{cr.longestIdle, &data.LongestIdle},
+		} {
+			ts, err := NewTimeStatistics(stat.h, cr.config.Percentiles)
+			if err != nil {
+				return nil, err
+			}
+			*stat.dest = &ts
+		}
+
+		q := queries{Min: math.MaxInt32, Percentiles: make(map[string]int)}
+		for _, count := range cr.queries {
+			q.Sum += count
+			if count < q.Min {
+				q.Min = count
+			}
+			if count > q.Max {
+				q.Max = count
+			}
+		}
+		q.Mean = float64(q.Sum) / float64(len(cr.queries))
+		for _, p := range cr.config.Percentiles {
+			q.Percentiles[Percentile{Percentile: p}.Name()] = cr.QueryPercentile(p)
+		}
+		data.Queries = &q
+	}
+
+	for idx, bucket := range cr.rates.Buckets {
+		data.Rates = append(data.Rates, rate{Second: idx, Connects: bucket.New, Disconnects: bucket.Closed})
+	}
+
+	return json.Marshal(data)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionReport(t *testing.T) {
+	query := func(stream int, at time.Duration, duration time.Duration) *Frame {
+		frame := &Frame{TCPStream: stream, TimeRelative: at, SrcHost: "10.0.0.1", SrcPort: 40000 + stream, DstHost: "10.0.0.2", DstPort: 3306, MySQLCommand: 3, MySQLQuery: NewMySQLQuery("SELECT 1")}
+		frame.MySQLQuery.Duration = duration
+		return frame
+	}
+
+	frames := Frames{
+		// a pooled connection already open when the capture started, closed by the server after idling
+		query(1, 0, 10*time.Millisecond),
+		query(1, 500*time.Millisecond, 10*time.Millisecond),
+		{TCPStream: 1, TimeRelative: 2 * time.Second, SrcHost: "10.0.0.2", SrcPort: 3306, DstHost: "10.0.0.1", DstPort: 40001, TCPFin: true},
+		// a connection per query, logging in and closed by the client
+		{TCPStream: 2, TimeRelative: time.Second, SrcHost: "10.0.0.1", SrcPort: 40002, DstHost: "10.0.0.2", DstPort: 3306, MySQLUser: "app"},
+		query(2, time.Second, 10*time.Millisecond),
+		{TCPStream: 2, TimeRelative: 1100 * time.Millisecond, SrcHost: "10.0.0.1", SrcPort: 40002, DstHost: "10.0.0.2", DstPort: 3306, MySQLCommand: 1},
+		// a connection without queries reset by the server right after its SYN
+		{TCPStream: 3, TimeRelative: 1500 * time.Millisecond, SrcPort: 40003, DstPort: 3306, TCPSyn: true},
+		{TCPStream: 3, TimeRelative: 1600 * time.Millisecond, SrcPort: 3306, DstPort: 40003, TCPReset: true},
+		// a connection without queries closed by the client, endpoints unknown to the tracker
+		{TCPStream: 4, TimeRelative: 2500 * time.Millisecond, SrcPort: 40004, DstPort: 3306, TCPFin: true},
+	}
+
+	report := NewConnectionReport(DefaultStatisticsConfig(), frames, Transactions{}, 3306)
+	assert.Len(t, report.Connections, 4)
+
+	pooled := report.Connections[0]
+	assert.Equal(t, ClosedByServerFin, pooled.ClosedBy)
+	assert.Equal(t, 2, pooled.Queries)
+	assert.Equal(t, 2*time.Second, pooled.Lifetime())
+	assert.Equal(t, 20*time.Millisecond, pooled.Busy)
+	assert.Equal(t, 1980*time.Millisecond, pooled.Idle)
+	assert.Equal(t, 1490*time.Millisecond, pooled.LongestIdle)
+
+	assert.Equal(t, ClosedByComQuit, report.Connections[1].ClosedBy)
+	assert.Equal(t, ClosedByRST, report.Connections[2].ClosedBy)
+	assert.Equal(t, ClosedByClientFin, report.Connections[3].ClosedBy)
+
+	assert.Equal(t, map[string]int{ClosedByServerFin: 1, ClosedByComQuit: 1, ClosedByRST: 1, ClosedByClientFin: 1}, report.ClosedBy)
+	assert.Equal(t, 2, report.QueryPercentile(100))
+	assert.Equal(t, 0, report.QueryPercentile(50))
+
+	// the pooled connection and the one closed by the client without queries
+	// were open before the capture started, so they are not connects
+	assert.True(t, pooled.AlreadyOpen)
+	assert.False(t, report.Connections[1].AlreadyOpen)
+	assert.False(t, report.Connections[2].AlreadyOpen)
+	assert.True(t, report.Connections[3].AlreadyOpen)
+	assert.Equal(t, 2, report.AlreadyOpen)
+
+	// connects and disconnects per second
+	assert.Equal(t, 0, report.rates.Buckets[0].New)
+	assert.Equal(t, 2, report.rates.Buckets[1].New)
+	assert.Equal(t, 2, report.rates.Buckets[1].Closed)
+	assert.Equal(t, 2, report.rates.Buckets[2].Closed)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the outcomes of comparing a fingerprint between two analyses
+const (
+	DiffNew       = "new"
+	DiffVanished  = "vanished"
+	DiffRegressed = "regressed"
+	DiffImproved  = "improved"
+	DiffUnchanged = "unchanged"
+)
+
+// DiffConfig configures when a change counts as a regression or improvement
+type DiffConfig struct {
+	// Alpha is the significance level of the statistical tests
+	Alpha float64
+	// MinChange is the smallest relative change of p50 or p95 latency that is reported
+	MinChange float64
+}
+
+func DefaultDiffConfig() DiffConfig {
+	return DiffConfig{Alpha: 0.01, MinChange: 0.1}
+}
+
+// Diff compares two analyses, e.g. before and after a deploy, given as summaries
+type Diff struct {
+	config     StatisticsConfig
+	diffConfig DiffConfig
+	Queries    []*DiffEntry
+	// Transactions are matched by their transaction fingerprint
+	Transactions []*DiffEntry
+}
+
+// DiffEntry is one fingerprint in both analyses
+type DiffEntry struct {
+	Fingerprint []string
+	Status      string
+	Before      *DiffSide
+	After       *DiffSide
+	// LatencyP is the p-value of the latency distributions being the same
+	LatencyP float64
+	// ErrorRateP is the p-value of the error rates being the same
+	ErrorRateP float64
+	// RateP is the p-value of the rates per second being the same
+	RateP       float64
+	RateChanged bool
+	// Impact is the change in server time per second of capture, the ranking of the diff
+	Impact time.Duration
+}
+
+// DiffSide is a fingerprint in one of the analyses
+type DiffSide struct {
+	Count  int
+	Errors int
+	// Duration is how long the analysis was, zero if unknown
+	Duration time.Duration
+	// Latency are the query or transaction durations
+	Latency *Histogram
+	// Waste are the transaction waste durations, nil for queries
+	Waste *Histogram
+}
+
+// Rate returns the count per second, zero if the duration of the analysis is unknown
+func (s *DiffSide) Rate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Count) / s.Duration.Seconds()
+}
+
+// load returns the server time per second of capture, or the total server
+// time if the duration of the analysis is unknown
+func (s *DiffSide) load() time.Duration {
+	if s == nil {
+		return 0
+	}
+	if s.Duration <= 0 {
+		return s.Latency.Sum
+	}
+	return time.Duration(float64(s.Latency.Sum) / s.Duration.Seconds())
+}
+
+// NewDiff compares the queries and normalized transactions of two summaries
+func NewDiff(config StatisticsConfig, diffConfig DiffConfig, before *Summary, after *Summary) (Diff, error) {
+	diff := Diff{config: config, diffConfig: diffConfig}
+
+	if before.RelativeError != after.RelativeError {
+		return diff, fmt.Errorf("cannot compare summaries with relative error %v and %v", before.RelativeError, after.RelativeError)
+	}
+	if before.fingerprintStrategy() != after.fingerprintStrategy() {
+		return diff, fmt.Errorf("cannot compare summaries with transaction fingerprint strategies %s and %s", before.fingerprintStrategy(), after.fingerprintStrategy())
+	}
+
+	queries := make(map[string]*DiffEntry)
+	for idx, summary := range []*Summary{before, after} {
+		fingerprints, err := summary.GroupBy("fingerprint", config)
+		if err != nil {
+			return diff, err
+		}
+		errors, err := summary.GroupBy("fingerprint,error", config)
+		if err != nil {
+			return diff, err
+		}
+
+		for _, group := range fingerprints.Groups() {
+			entry, ok := queries[group.Values[0]]
+			if !ok {
+				entry = &DiffEntry{Fingerprint: group.Values}
+				queries[group.Values[0]] = entry
+			}
+
+			side := &DiffSide{Count: group.Durations.Count, Duration: summary.CaptureDuration(), Latency: group.Durations}
+			if idx == 0 {
+				entry.Before = side
+			} else {
+				entry.After = side
+			}
+		}
+
+		for _, group := range errors.Groups() {
+			entry, ok := queries[group.Values[0]]
+			if !ok {
+				continue
+			}
+			side := entry.Before
+			if idx == 1 {
+				side = entry.After
+			}
+			side.Errors += group.Durations.Count
+		}
+	}
+
+	transactions := make(map[string]*DiffEntry)
+	for idx, summary := range []*Summary{before, after} {
+		nts := summary.NormalizedTransactionsWith(config)
+		for key, nt := range nts.Transactions {
+			entry, ok := transactions[key]
+			if !ok {
+				entry = &DiffEntry{Fingerprint: nt.Fingerprint}
+				transactions[key] = entry
+			}
+
+			side := &DiffSide{Count: nt.transactionDurations.Count, Duration: summary.CaptureDuration(), Latency: nt.transactionDurations, Waste: nt.wasteDurations}
+			if idx == 0 {
+				entry.Before = side
+			} else {
+				entry.After = side
+			}
+		}
+	}
+
+	diff.Queries = diff.rank(queries)
+	diff.Transactions = diff.rank(transactions)
+
+	return diff, nil
+}
+
+// rank compares every entry and orders them by impact, biggest first
+func (d *Diff) rank(entries map[string]*DiffEntry) []*DiffEntry {
+	result := make([]*DiffEntry, 0, len(entries))
+	for _, entry := range entries {
+		d.compare(entry)
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := absDuration(result[i].Impact), absDuration(result[j].Impact)
+		if a != b {
+			return a > b
+		}
+		return strings.Join(result[i].Fingerprint, "\n") < strings.Join(result[j].Fingerprint, "\n")
+	})
+
+	return result
+}
+
+// compare fills in the status, tests and impact of the entry
+func (d *Diff) compare(entry *DiffEntry) {
+	entry.Impact = entry.After.load() - entry.Before.load()
+	entry.LatencyP, entry.ErrorRateP, entry.RateP = 1, 1, 1
+
+	switch {
+	case entry.Before == nil:
+		entry.Status = DiffNew
+		return
+	case entry.After == nil:
+		entry.Status = DiffVanished
+		return
+	}
+
+	before, after := entry.Before, entry.After
+	entry.LatencyP = mannWhitney(before.Latency, after.Latency)
+	entry.ErrorRateP = twoProportions(before.Errors, before.Count, after.Errors, after.Count)
+	if before.Duration > 0 && after.Duration > 0 {
+		entry.RateP = poissonRates(before.Count, before.Duration, after.Count, after.Duration)
+		entry.RateChanged = entry.RateP < d.diffConfig.Alpha
+	}
+
+	// the latency moved if the distributions differ and a reported percentile
+	// changed by enough to matter
+	latency := 0
+	if entry.LatencyP < d.diffConfig.Alpha {
+		for _, q := range []float64{0.5, 0.95} {
+			b, a := before.Latency.Quantile(q), after.Latency.Quantile(q)
+			if b <= 0 {
+				continue
+			}
+			change := float64(a-b) / float64(b)
+			if change >= d.diffConfig.MinChange {
+				latency = 1
+			} else if change <= -d.diffConfig.MinChange && latency == 0 {
+				latency = -1
+			}
+		}
+	}
+
+	errors := 0
+	if entry.ErrorRateP < d.diffConfig.Alpha {
+		if float64(after.Errors)/float64(after.Count) > float64(before.Errors)/float64(before.Count) {
+			errors = 1
+		} else {
+			errors = -1
+		}
+	}
+
+	switch {
+	case latency > 0 || errors > 0:
+		entry.Status = DiffRegressed
+	case latency < 0 || errors < 0:
+		entry.Status = DiffImproved
+	default:
+		entry.Status = DiffUnchanged
+	}
+}
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test of the
+// durations of two histograms with the same relative error. Durations in the
+// same bucket are ties, so the test works off the histograms alone.
+func mannWhitney(a, b *Histogram) float64 {
+	n1, n2 := float64(a.Count), float64(b.Count)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	// every bucket of either histogram, in ascending order, zero first
+	indexes := make(map[int]bool)
+	for idx := range a.Buckets {
+		indexes[idx] = true
+	}
+	for idx := range b.Buckets {
+		indexes[idx] = true
+	}
+	sorted := make([]int, 0, len(indexes))
+	for idx := range indexes {
+		sorted = append(sorted, idx)
+	}
+	sort.Ints(sorted)
+
+	counts := [][2]int{{a.Zero, b.Zero}}
+	for _, idx := range sorted {
+		counts = append(counts, [2]int{a.Buckets[idx], b.Buckets[idx]})
+	}
+
+	var rankSum, ties, seen float64
+	for _, c := range counts {
+		t := float64(c[0] + c[1])
+		if t == 0 {
+			continue
+		}
+		rankSum += float64(c[0]) * (seen + (t+1)/2)
+		ties += t*t*t - t
+		seen += t
+	}
+
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+
+	return twoSided((u - n1*n2/2) / math.Sqrt(variance))
+}
+
+// twoProportions returns the two-sided p-value of the z-test of x1/n1 and x2/n2 being the same
+func twoProportions(x1, n1, x2, n2 int) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	pooled := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 1
+	}
+
+	return twoSided((float64(x2)/float64(n2) - float64(x1)/float64(n1)) / se)
+}
+
+// poissonRates returns the two-sided p-value of n1 events in d1 and n2 events in
+// d2 coming from the same rate: given n1+n2 events, n1 is binomial with
+// p = d1/(d1+d2), approximated by a normal distribution
+func poissonRates(n1 int, d1 time.Duration, n2 int, d2 time.Duration) float64 {
+	n := float64(n1 + n2)
+	if n == 0 {
+		return 1
+	}
+
+	p := float64(d1) / float64(d1+d2)
+	se := math.Sqrt(n * p * (1 - p))
+	if se == 0 {
+		return 1
+	}
+
+	return twoSided((float64(n1) - n*p) / se)
+}
+
+// twoSided returns the two-sided p-value of a standard normal z score
+func twoSided(z float64) float64 {
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func (d *Diff) MarshalJSON() ([]byte, error) {
+	type side struct {
+		Count int     `json:"count"`
+		Rate  float64 `json:"rate,omitempty"`
+		// durations in milliseconds
+		Latency         *TimeStatistics `json:"latency"`
+		ErrorRate       float64         `json:"error_rate"`
+		WastePercentage *float64        `json:"waste_percentage,omitempty"`
+	}
+	type entry struct {
+		Fingerprint []string `json:"fingerprint"`
+		Status      string   `json:"status"`
+		Before      *side    `json:"before,omitempty"`
+		After       *side    `json:"after,omitempty"`
+		LatencyP    float64  `json:"latency_p_value"`
+		ErrorRateP  float64  `json:"error_rate_p_value"`
+		RateP       float64  `json:"rate_p_value"`
+		RateChanged bool     `json:"rate_changed"`
+		// Impact is the change in server milliseconds per second of capture
+		Impact float64 `json:"impact"`
+	}
+
+	convertSide := func(s *DiffSide) (*side, error) {
+		if s == nil {
+			return nil, nil
+		}
+
+		ts, err := NewTimeStatistics(s.Latency, d.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &side{Count: s.Count, Rate: s.Rate(), Latency: &ts}
+		if s.Count > 0 {
+			result.ErrorRate = float64(s.Errors) / float64(s.Count)
+		}
+		if s.Waste != nil && s.Latency.Sum > 0 {
+			waste := float64(s.Waste.Sum) / float64(s.Latency.Sum) * 100
+			result.WastePercentage = &waste
+		}
+		return result, nil
+	}
+
+	convert := func(entries []*DiffEntry) ([]entry, error) {
+		result := []entry{}
+		for _, e := range entries {
+			before, err := convertSide(e.Before)
+			if err != nil {
+				return nil, err
+			}
+			after, err := convertSide(e.After)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, entry{
+				Fingerprint: e.Fingerprint,
+				Status:      e.Status,
+				Before:      before,
+				After:       after,
+				LatencyP:    e.LatencyP,
+				ErrorRateP:  e.ErrorRateP,
+				RateP:       e.RateP,
+				RateChanged: e.RateChanged,
+				Impact:      float64(e.Impact) / float64(time.Millisecond),
+			})
+		}
+		return result, nil
+	}
+
+	queries, err := convert(d.Queries)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := convert(d.Transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Queries      []entry `json:"queries"`
+		Transactions []entry `json:"transactions"`
+	}{queries, transactions})
+}
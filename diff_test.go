@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	config := DefaultStatisticsConfig()
+
+	// capture builds a summary of a capture of one second
+	capture := func(queries map[string][]time.Duration, errors map[string]int) Summary {
+		fp := NewFrameParser()
+		stream := 0
+		for query, durations := range queries {
+			for i, d := range durations {
+				frame := &Frame{TCPStream: stream, TimeRelative: time.Duration(i) * time.Millisecond, MySQLQuery: NewMySQLQuery(query)}
+				frame.MySQLQuery.Duration = d
+				if i < errors[query] {
+					frame.MySQLQuery.ErrorCode = 1205
+				}
+				fp.Frames = append(fp.Frames, frame)
+			}
+			stream++
+		}
+		fp.Frames = append(fp.Frames, &Frame{TimeRelative: time.Second})
+
+		summary, err := NewSummary("db1", config, &fp, SummaryGroupBys)
+		assert.NoError(t, err)
+		return summary
+	}
+
+	repeat := func(n int, d time.Duration) []time.Duration {
+		var result []time.Duration
+		for i := 0; i < n; i++ {
+			// a bit of noise
+			result = append(result, d+time.Duration(i%7)*d/50)
+		}
+		return result
+	}
+
+	before := capture(map[string][]time.Duration{
+		"SELECT 1 FROM slower": repeat(200, time.Millisecond),
+		"SELECT 1 FROM same":   repeat(200, time.Millisecond),
+		"SELECT 1 FROM few":    repeat(3, time.Millisecond),
+		"SELECT 1 FROM gone":   repeat(10, time.Millisecond),
+		"SELECT 1 FROM errors": repeat(200, time.Millisecond),
+	}, nil)
+	after := capture(map[string][]time.Duration{
+		"SELECT 1 FROM slower": repeat(200, 2*time.Millisecond),
+		"SELECT 1 FROM same":   repeat(200, time.Millisecond),
+		"SELECT 1 FROM few":    repeat(3, 3*time.Millisecond),
+		"SELECT 1 FROM added":  repeat(10, time.Millisecond),
+		"SELECT 1 FROM errors": repeat(200, time.Millisecond),
+	}, map[string]int{"SELECT 1 FROM errors": 40})
+
+	diff, err := NewDiff(config, DefaultDiffConfig(), &before, &after)
+	assert.NoError(t, err)
+
+	statuses := make(map[string]string)
+	for _, entry := range diff.Queries {
+		statuses[entry.Fingerprint[0]] = entry.Status
+	}
+	assert.Equal(t, map[string]string{
+		"select ? from slower": DiffRegressed,
+		"select ? from same":   DiffUnchanged,
+		// three queries are too few to tell
+		"select ? from few":    DiffUnchanged,
+		"select ? from gone":   DiffVanished,
+		"select ? from added":  DiffNew,
+		"select ? from errors": DiffRegressed,
+	}, statuses)
+
+	// the biggest change in server time comes first
+	assert.Equal(t, "select ? from slower", diff.Queries[0].Fingerprint[0])
+	assert.Equal(t, time.Second, after.CaptureDuration())
+	assert.Equal(t, 200.0, diff.Queries[0].After.Rate())
+	assert.False(t, diff.Queries[0].RateChanged)
+}
+
+func TestMannWhitney(t *testing.T) {
+	a := NewHistogram(DefaultRelativeError)
+	b := NewHistogram(DefaultRelativeError)
+	for i := 0; i < 50; i++ {
+		a.Add(time.Duration(i+1) * time.Millisecond)
+		b.Add(time.Duration(i+1) * time.Millisecond)
+	}
+	assert.InDelta(t, 1, mannWhitney(a, b), 0.001)
+
+	for i := 0; i < 50; i++ {
+		b.Add(time.Duration(i+100) * time.Millisecond)
+	}
+	assert.Less(t, mannWhitney(a, b), 0.001)
+
+	assert.Equal(t, 1.0, twoProportions(0, 10, 0, 10))
+	assert.Less(t, twoProportions(1, 1000, 100, 1000), 0.001)
+	assert.InDelta(t, 1, poissonRates(100, time.Second, 200, 2*time.Second), 0.001)
+}
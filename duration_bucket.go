@@ -1,71 +1,104 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"io"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-type DurationBuckets struct {
-	// interval is the bucket size
-	interval time.Duration
-	buckets  []DurationBucket
-	// the most recent bucket index
-	// buckets must be added in order
-	index         int
-	closedStreams map[int]bool
-	seenStreams   map[int]bool
+// Interval is a span of the capture timeline, such as a query from the time
+// it was sent until its response
+type Interval struct {
+	Start time.Duration
+	End   time.Duration
 }
 
-func NewDurationBuckets(interval time.Duration) DurationBuckets {
-	return DurationBuckets{
-		interval:      interval,
-		index:         0,
-		closedStreams: make(map[int]bool),
-		seenStreams:   make(map[int]bool),
-	}
+// Timeline holds when every connection, query and transaction of a capture
+// was in progress. It is built from the complete capture, so frames that
+// arrive out of order end up in the right place.
+type Timeline struct {
+	Connections  []Interval
+	Queries      []Interval
+	Transactions []Interval
+	// Closed is when each connection that was explicitly closed was closed
+	Closed []time.Duration
+	// End is the end of the capture
+	End time.Duration
 }
 
-func (db *DurationBuckets) AddFrame(frame Frame) error {
-	idx := db.bucket(frame.TimeRelative)
-	if idx < db.index {
-		return errors.New("frame is in the past")
+// NewTimeline builds the timeline of the capture. Anything odd about the
+// capture is reported to diagnostics.
+func NewTimeline(frames Frames, transactions Transactions, diagnostics io.Writer) Timeline {
+	var timeline Timeline
+
+	streams := NewStreams(0)
+	for _, frame := range frames {
+		streams.AddFrame(frame)
+		if frame.TimeRelative > timeline.End {
+			timeline.End = frame.TimeRelative
+		}
+
+		// queries from the time they were sent until their response
+		if frame.MySQLQuery.Fingerprint != "" && frame.MySQLQuery.Duration > 0 {
+			end := frame.TimeRelative + frame.MySQLQuery.Duration
+			timeline.Queries = append(timeline.Queries, Interval{Start: frame.TimeRelative, End: end})
+			if end > timeline.End {
+				timeline.End = end
+			}
+		}
 	}
 
-	if len(db.buckets) == 0 {
-		db.buckets = append(db.buckets, NewDurationBucket(DurationBucket{}, db.closedStreams))
+	// transactions from BEGIN until the COMMIT response
+	for _, t := range transactions.Transactions {
+		start := t.Frames[0].TimeRelative
+		timeline.Transactions = append(timeline.Transactions, Interval{Start: start, End: start + t.TotalDuration()})
 	}
 
-	// expand the bucket and move the bucket pointer
-	for i := db.index; i < idx; i++ {
-		//		fmt.Printf("Adding bucket i: %d db.index: %d, idx: %d, len(db.buckets): %d, frame.TimeRelative: %s\n", i, db.index, idx, len(db.buckets), frame.TimeRelative)
-		db.buckets = append(db.buckets, NewDurationBucket(db.buckets[i], db.closedStreams))
+	late := 0
+	for _, stream := range streams.Streams() {
+		timeline.Connections = append(timeline.Connections, stream.Interval(timeline.End))
+		if stream.Closed {
+			timeline.Closed = append(timeline.Closed, stream.ClosedAt)
+			if stream.LastOpen > stream.ClosedAt {
+				late++
+			}
+		}
+	}
+	if late > 0 {
+		fmt.Fprintf(diagnostics, "%d streams had traffic after they were closed\n", late)
 	}
-	db.index = idx
-	db.buckets[idx].AddFrame(frame, db.seenStreams, db.closedStreams)
 
-	return nil
+	return timeline
 }
 
-func (db *DurationBuckets) TSV() string {
-	var builder strings.Builder
-
-	builder.WriteString("Time,Concurrent,New,Closed\n")
-
-	for idx, bucket := range db.buckets {
-		builder.WriteString((time.Duration(idx) * db.interval).String())
-		builder.WriteString("\t")
-		builder.WriteString(strconv.Itoa(bucket.CountConcurrent()))
-		builder.WriteString("\t")
-		builder.WriteString(strconv.Itoa(bucket.CountNew()))
-		builder.WriteString("\t")
-		builder.WriteString(strconv.Itoa(bucket.CountClosed()))
-		builder.WriteString("\n")
+// ParseIntervals parses a comma separated list of bucket sizes, e.g. "10ms,100ms,1s"
+func ParseIntervals(spec string) ([]time.Duration, error) {
+	var result []time.Duration
+	for _, s := range strings.Split(spec, ",") {
+		interval, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval %s must be positive", interval)
+		}
+		result = append(result, interval)
 	}
+	return result, nil
+}
 
-	return builder.String()
+// DurationBuckets buckets the capture timeline at one resolution
+type DurationBuckets struct {
+	// interval is the bucket size
+	interval time.Duration
+}
+
+func NewDurationBuckets(interval time.Duration) DurationBuckets {
+	return DurationBuckets{interval: interval}
 }
 
 // bucket returns the bucket index for the given duration
@@ -73,73 +106,282 @@ func (db *DurationBuckets) bucket(d time.Duration) int {
 	return int(d / db.interval)
 }
 
-type DurationBucket struct {
-	// streams is a map used to count the number of streams that fall into this bucket
-	// key is tcp stream
-	streams map[int]bool
+// Series buckets the timeline
+func (db *DurationBuckets) Series(timeline Timeline, percentiles []float64) ConcurrencySeries {
+	count := db.bucket(timeline.End) + 1
+
+	series := ConcurrencySeries{
+		Interval: db.interval,
+		Buckets:  make([]ConcurrencyBucket, count),
+	}
+
+	connections := db.Levels(timeline.Connections, timeline.End, percentiles)
+	queries := db.Levels(timeline.Queries, timeline.End, percentiles)
+	transactions := db.Levels(timeline.Transactions, timeline.End, percentiles)
+
+	for idx := range series.Buckets {
+		series.Buckets[idx].Connections = connections[idx]
+		series.Buckets[idx].Queries = queries[idx]
+		series.Buckets[idx].Transactions = transactions[idx]
+	}
+	for _, connection := range timeline.Connections {
+		series.Buckets[db.bucket(connection.Start)].New++
+	}
+	for _, closed := range timeline.Closed {
+		series.Buckets[db.bucket(closed)].Closed++
+	}
 
-	newStreams    map[int]bool
-	closedStreams map[int]bool
+	return series
 }
 
-func NewDurationBucket(prevdb DurationBucket, closedStreams map[int]bool) DurationBucket {
-	db := DurationBucket{
-		streams:       make(map[int]bool),
-		newStreams:    make(map[int]bool),
-		closedStreams: make(map[int]bool),
+// Levels sweeps over the intervals and returns how many of them were in
+// progress within each bucket up to end, weighted by time
+func (db *DurationBuckets) Levels(intervals []Interval, end time.Duration, percentiles []float64) []Level {
+	type event struct {
+		at    time.Duration
+		delta int
 	}
 
-	// copy the previous streams over since they are still active
-	// until the fin/rst is received
-	for stream := range prevdb.streams {
-		// this stream has already been closed, don't copy it over
-		// this is a hack because something else is wonky with the stream
-		// closing detection
-		if _, ok := closedStreams[stream]; ok {
-			fmt.Println("Found stream that was closed but was in the previous bucket", stream)
+	var events []event
+	for _, interval := range intervals {
+		if interval.End < interval.Start {
 			continue
 		}
+		events = append(events, event{at: interval.Start, delta: 1}, event{at: interval.End, delta: -1})
+	}
 
-		db.streams[stream] = true
+	// something ending at the same instant as something else starts is not concurrent with it
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].delta < events[j].delta
+	})
+
+	count := db.bucket(end) + 1
+	// time spent at each level in each bucket
+	times := make([]map[int]time.Duration, count)
+	peaks := make([]int, count)
+	for idx := range times {
+		times[idx] = make(map[int]time.Duration)
 	}
 
-	return db
+	level := 0
+	cursor := time.Duration(0)
+	for _, e := range events {
+		if e.at > end {
+			break
+		}
+
+		for cursor < e.at {
+			idx := db.bucket(cursor)
+			next := time.Duration(idx+1) * db.interval
+			if next > e.at {
+				next = e.at
+			}
+			times[idx][level] += next - cursor
+			cursor = next
+		}
+
+		level += e.delta
+		if idx := db.bucket(e.at); level > peaks[idx] {
+			peaks[idx] = level
+		}
+	}
+
+	result := make([]Level, count)
+	for idx, levels := range times {
+		// the last bucket ends with the capture
+		length := db.interval
+		if start := time.Duration(idx) * db.interval; end-start < length {
+			length = end - start
+		}
+
+		// the sweep covered everything up to the last event,
+		// the rest was spent at the level after it
+		var covered time.Duration
+		for _, d := range levels {
+			covered += d
+		}
+		if length > covered {
+			levels[level] += length - covered
+		}
+
+		result[idx] = newLevel(levels, peaks[idx], length, percentiles)
+	}
+
+	return result
+}
+
+// Level is how many connections, queries or transactions were in progress
+// within one bucket, weighted by time
+type Level struct {
+	Min int     `json:"min"`
+	Max int     `json:"max"`
+	Avg float64 `json:"avg"`
+	// Percentiles are not mergeable, so they are left out of summaries
+	Percentiles []LevelPercentile `json:"-"`
 }
 
-func (db *DurationBucket) AddFrame(frame Frame, seenStreams map[int]bool, closedStreams map[int]bool) {
-	// if the TCP connection was closed, reset, or mysql quit was received
-	// make the stream as closed
-	if frame.TCPFin || frame.TCPReset || frame.MySQLCommand == 1 {
-		delete(db.streams, frame.TCPStream)
+type LevelPercentile struct {
+	Percentile float64
+	Value      int
+}
 
-		if _, ok := closedStreams[frame.TCPStream]; !ok {
-			db.closedStreams[frame.TCPStream] = true
+// newLevel summarizes the time spent at each level within a bucket of the given length
+func newLevel(times map[int]time.Duration, peak int, length time.Duration, percentiles []float64) Level {
+	var sorted []int
+	var weighted float64
+	for l, d := range times {
+		if d > 0 {
+			sorted = append(sorted, l)
+			weighted += float64(l) * float64(d)
 		}
-		closedStreams[frame.TCPStream] = true
-		return
 	}
+	sort.Ints(sorted)
 
-	// this stream has already been closed
-	if _, ok := closedStreams[frame.TCPStream]; ok {
-		return
+	result := Level{Max: peak}
+	if len(sorted) > 0 {
+		result.Min = sorted[0]
+		if sorted[len(sorted)-1] > result.Max {
+			result.Max = sorted[len(sorted)-1]
+		}
+	}
+	if length > 0 {
+		result.Avg = weighted / float64(length)
 	}
 
-	if _, ok := seenStreams[frame.TCPStream]; !ok {
-		db.newStreams[frame.TCPStream] = true
-		seenStreams[frame.TCPStream] = true
+	for _, p := range percentiles {
+		target := time.Duration(math.Ceil(p / 100 * float64(length)))
+		var seen time.Duration
+		value := 0
+		for _, l := range sorted {
+			seen += times[l]
+			value = l
+			if seen >= target {
+				break
+			}
+		}
+		result.Percentiles = append(result.Percentiles, LevelPercentile{Percentile: p, Value: value})
 	}
 
-	db.streams[frame.TCPStream] = true
+	return result
+}
+
+// ConcurrencySeries is the concurrency of connections, queries and transactions per bucket
+type ConcurrencySeries struct {
+	// Start is the absolute time the first bucket starts at, zero if
+	// frame.time_epoch was not captured
+	Start    time.Time           `json:"start"`
+	Interval time.Duration       `json:"interval"`
+	Buckets  []ConcurrencyBucket `json:"buckets"`
 }
 
-func (db *DurationBucket) CountConcurrent() int {
-	return len(db.streams)
+type ConcurrencyBucket struct {
+	Connections  Level `json:"connections"`
+	Queries      Level `json:"queries"`
+	Transactions Level `json:"transactions"`
+	// New and Closed count the connections opened and closed in the bucket
+	New    int `json:"new"`
+	Closed int `json:"closed"`
 }
 
-func (db *DurationBucket) CountNew() int {
-	return len(db.newStreams)
+// Add sums another series with the same interval into cs, e.g. to combine the
+// connections of several hosts. The min and max of the sum are the sums of the
+// min and max of each series, so they bound the actual min and max.
+// Series with a start are aligned on absolute time, rounded to whole buckets:
+// they are summed where they overlap and extend cs where they do not, so
+// consecutive capture windows follow each other. Series without a start are
+// aligned on their first bucket.
+func (cs *ConcurrencySeries) Add(other *ConcurrencySeries) error {
+	if len(cs.Buckets) == 0 {
+		cs.Interval = other.Interval
+		cs.Start = other.Start
+	}
+	if cs.Interval != other.Interval {
+		return fmt.Errorf("cannot add a series with interval %s to a series with interval %s", other.Interval, cs.Interval)
+	}
+
+	// offset is the bucket of cs the first bucket of other falls into
+	offset := 0
+	if !cs.Start.IsZero() && !other.Start.IsZero() {
+		offset = int(math.Round(float64(other.Start.Sub(cs.Start)) / float64(cs.Interval)))
+		if offset < 0 {
+			cs.Buckets = append(make([]ConcurrencyBucket, -offset), cs.Buckets...)
+			cs.Start = cs.Start.Add(time.Duration(offset) * cs.Interval)
+			offset = 0
+		}
+	}
+
+	add := func(a *Level, b Level) {
+		a.Min += b.Min
+		a.Max += b.Max
+		a.Avg += b.Avg
+		a.Percentiles = nil
+	}
+
+	for idx, bucket := range other.Buckets {
+		idx += offset
+		for idx >= len(cs.Buckets) {
+			cs.Buckets = append(cs.Buckets, ConcurrencyBucket{})
+		}
+		add(&cs.Buckets[idx].Connections, bucket.Connections)
+		add(&cs.Buckets[idx].Queries, bucket.Queries)
+		add(&cs.Buckets[idx].Transactions, bucket.Transactions)
+		cs.Buckets[idx].New += bucket.New
+		cs.Buckets[idx].Closed += bucket.Closed
+	}
+
+	return nil
 }
 
-func (db *DurationBucket) CountClosed() int {
-	return len(db.closedStreams)
+// ConcurrencyTSV writes the buckets of every series, one row per bucket
+// with the interval of its series in the first column
+func ConcurrencyTSV(series []ConcurrencySeries, percentiles []float64) string {
+	var builder strings.Builder
+
+	builder.WriteString("Interval\tTime")
+	for _, name := range []string{"Connections", "Queries", "Transactions"} {
+		builder.WriteString("\t" + name + "Min\t" + name + "Max\t" + name + "Avg")
+		for _, p := range percentiles {
+			builder.WriteString("\t" + name + "P" + strconv.FormatFloat(p, 'f', -1, 64))
+		}
+		if name == "Connections" {
+			builder.WriteString("\tNew\tClosed")
+		}
+	}
+	builder.WriteString("\n")
+
+	for _, s := range series {
+		for idx, bucket := range s.Buckets {
+			builder.WriteString(s.Interval.String())
+			builder.WriteString("\t")
+			builder.WriteString((time.Duration(idx) * s.Interval).String())
+
+			for i, level := range []Level{bucket.Connections, bucket.Queries, bucket.Transactions} {
+				builder.WriteString("\t")
+				builder.WriteString(strconv.Itoa(level.Min))
+				builder.WriteString("\t")
+				builder.WriteString(strconv.Itoa(level.Max))
+				builder.WriteString("\t")
+				builder.WriteString(strconv.FormatFloat(level.Avg, 'f', 2, 64))
+				for j := range percentiles {
+					builder.WriteString("\t")
+					if j < len(level.Percentiles) {
+						builder.WriteString(strconv.Itoa(level.Percentiles[j].Value))
+					}
+				}
+
+				if i == 0 {
+					builder.WriteString("\t")
+					builder.WriteString(strconv.Itoa(bucket.New))
+					builder.WriteString("\t")
+					builder.WriteString(strconv.Itoa(bucket.Closed))
+				}
+			}
+			builder.WriteString("\n")
+		}
+	}
+
+	return builder.String()
 }
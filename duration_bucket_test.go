@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"testing"
 	"time"
 
@@ -25,3 +26,87 @@ func TestBucket(t *testing.T) {
 		assert.Equal(t, test.expected, db.bucket(test.duration))
 	}
 }
+
+func TestDurationBucketsLevels(t *testing.T) {
+	intervals := []Interval{
+		// two queries overlapping for 20ms in the first bucket
+		{Start: 0, End: 50 * time.Millisecond},
+		{Start: 30 * time.Millisecond, End: 60 * time.Millisecond},
+		// a query spanning into the second bucket
+		{Start: 90 * time.Millisecond, End: 150 * time.Millisecond},
+		// back to back is not concurrent
+		{Start: 150 * time.Millisecond, End: 160 * time.Millisecond},
+	}
+
+	db := NewDurationBuckets(100 * time.Millisecond)
+	levels := db.Levels(intervals, 200*time.Millisecond, []float64{50, 95})
+	assert.Len(t, levels, 3)
+
+	assert.Equal(t, 0, levels[0].Min)
+	assert.Equal(t, 2, levels[0].Max)
+	assert.InDelta(t, 0.9, levels[0].Avg, 0.0001)
+	assert.Equal(t, []LevelPercentile{{Percentile: 50, Value: 1}, {Percentile: 95, Value: 2}}, levels[0].Percentiles)
+
+	assert.Equal(t, 1, levels[1].Max)
+	assert.InDelta(t, 0.6, levels[1].Avg, 0.0001)
+	assert.Equal(t, []LevelPercentile{{Percentile: 50, Value: 1}, {Percentile: 95, Value: 1}}, levels[1].Percentiles)
+}
+
+func TestDurationBucketsSeries(t *testing.T) {
+	// frames out of order, the stream closes in the second bucket
+	frames := Frames{
+		{TCPStream: 1, TimeRelative: 150 * time.Millisecond, TCPFin: true},
+		{TCPStream: 1, TimeRelative: 10 * time.Millisecond},
+		{TCPStream: 2, TimeRelative: 50 * time.Millisecond},
+		{TCPStream: 2, TimeRelative: 250 * time.Millisecond},
+	}
+
+	timeline := NewTimeline(frames, NewTransactions(), io.Discard)
+	db := NewDurationBuckets(100 * time.Millisecond)
+	series := db.Series(timeline, nil)
+
+	assert.Len(t, series.Buckets, 3)
+	assert.Equal(t, Level{Min: 0, Max: 2, Avg: 1.4}, series.Buckets[0].Connections)
+	assert.Equal(t, Level{Min: 1, Max: 2, Avg: 1.5}, series.Buckets[1].Connections)
+	assert.Equal(t, 2, series.Buckets[0].New)
+	assert.Equal(t, 1, series.Buckets[1].Closed)
+}
+
+func TestConcurrencySeriesAdd(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	series := func(start time.Time, connections ...int) *ConcurrencySeries {
+		s := &ConcurrencySeries{Start: start, Interval: 100 * time.Millisecond}
+		for _, c := range connections {
+			s.Buckets = append(s.Buckets, ConcurrencyBucket{Connections: Level{Max: c}})
+		}
+		return s
+	}
+	maxes := func(s ConcurrencySeries) []int {
+		var result []int
+		for _, bucket := range s.Buckets {
+			result = append(result, bucket.Connections.Max)
+		}
+		return result
+	}
+
+	// overlapping series are summed where they overlap
+	var total ConcurrencySeries
+	assert.NoError(t, total.Add(series(start, 1, 1, 1)))
+	assert.NoError(t, total.Add(series(start.Add(200*time.Millisecond), 2, 2)))
+	assert.Equal(t, []int{1, 1, 3, 2}, maxes(total))
+
+	// a series starting earlier extends the front
+	assert.NoError(t, total.Add(series(start.Add(-100*time.Millisecond), 4)))
+	assert.Equal(t, []int{4, 1, 1, 3, 2}, maxes(total))
+	assert.Equal(t, start.Add(-100*time.Millisecond), total.Start)
+
+	// a later window is appended after the gap
+	assert.NoError(t, total.Add(series(start.Add(500*time.Millisecond), 5)))
+	assert.Equal(t, []int{4, 1, 1, 3, 2, 0, 5}, maxes(total))
+
+	// series without a start are aligned on their first bucket
+	var unaligned ConcurrencySeries
+	assert.NoError(t, unaligned.Add(series(time.Time{}, 1, 1)))
+	assert.NoError(t, unaligned.Add(series(time.Time{}, 2)))
+	assert.Equal(t, []int{3, 1}, maxes(unaligned))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,14 +13,49 @@ type transactionId struct {
 	NestingLevels int
 }
 
+// CaptureQuality counts what the parser could not make sense of, to tell how
+// far the analysis of a capture can be trusted
+type CaptureQuality struct {
+	Queries int
+	// Unanswered are queries without a response, e.g. because it was not captured
+	Unanswered int
+	// LostSegments are frames tshark flagged for a lost segment before them
+	LostSegments int
+	// LostTransactions were dropped because of a lost segment
+	LostTransactions int
+	// UnfinishedTransactions were still open at the end of the capture
+	UnfinishedTransactions int
+}
+
 type FrameParser struct {
 	Frames       Frames
 	Transactions Transactions
+	Quality      CaptureQuality
 	// a buffer of TCP Stream IDs that have not yet seen a mysql response
 	// with the key being the stream ID and the value the index of the original
 	// frame in the Frames slice
 	unRespondedStreams     map[int]int
 	openTransactionStreams map[int]*transactionId
+
+	// the state below lets a connection keep its stream ID and timeline
+	// across rotated capture files, where tshark numbers tcp.stream and
+	// frame.time_relative from zero in every file
+
+	// streams maps the endpoints of a connection to its stream ID
+	streams map[string]int
+	// fileStreams maps the tcp.stream of the current file to the stream ID
+	fileStreams map[int]int
+	// streamOffset is added to the tcp.stream of connections first seen in the current file
+	streamOffset int
+	// nextStream is one more than the highest stream ID seen so far
+	nextStream int
+	// startTime is the absolute time of the start of the first capture,
+	// if frame.time_epoch is captured
+	startTime time.Time
+	// timeOffset is added to the frame.time_relative of the current file
+	// if frame.time_epoch is not captured
+	timeOffset time.Duration
+	files      int
 }
 
 func NewFrameParser() FrameParser {
@@ -27,26 +63,93 @@ func NewFrameParser() FrameParser {
 		Transactions:           NewTransactions(),
 		unRespondedStreams:     make(map[int]int),
 		openTransactionStreams: make(map[int]*transactionId),
+		streams:                make(map[string]int),
+		fileStreams:            make(map[int]int),
 	}
 }
 
+// ParseRawFrames parses the frames of a single capture
 func (fp *FrameParser) ParseRawFrames(rawframes []rawframe) error {
-	for index, rawframe := range rawframes {
-		frame, err := fp.parseLayers(rawframe.Source.Layers, index)
-		if err != nil {
+	if err := fp.AddRawFrames(rawframes); err != nil {
+		return err
+	}
+
+	fp.Finish()
+	return nil
+}
+
+// AddRawFrames parses the frames of the next capture file. Queries,
+// transactions and connections that span files are carried over.
+func (fp *FrameParser) AddRawFrames(rawframes []rawframe) error {
+	fp.StartFile()
+
+	for _, rawframe := range rawframes {
+		if err := fp.AddRawFrame(rawframe); err != nil {
 			return err
 		}
-		fp.Frames = append(fp.Frames, frame)
 	}
 
+	return nil
+}
+
+// StartFile is called before the frames of the next capture file are added one by one
+func (fp *FrameParser) StartFile() {
+	if fp.files > 0 {
+		fp.streamOffset = fp.nextStream
+		fp.fileStreams = make(map[int]int)
+		if len(fp.Frames) > 0 {
+			fp.timeOffset = fp.Frames[len(fp.Frames)-1].TimeRelative
+		}
+	}
+	fp.files++
+}
+
+// AddRawFrame parses the next frame of the current capture file
+func (fp *FrameParser) AddRawFrame(rawframe rawframe) error {
+	frame, err := fp.parseLayers(rawframe.Source.Layers, len(fp.Frames))
+	if err != nil {
+		return err
+	}
+	fp.Frames = append(fp.Frames, frame)
+
+	return nil
+}
+
+// Finish is called after the last capture file has been added
+func (fp *FrameParser) Finish() {
 	// clean up any transactions that have not had a response
 	// this is probably the case when a connection was killed or
 	// the tcpdump ended before the transaction was completed
 	for _, txid := range fp.openTransactionStreams {
 		fp.Transactions.Delete(txid.Index)
+		fp.Quality.UnfinishedTransactions++
 	}
+	fp.Quality.Unanswered += len(fp.unRespondedStreams)
+}
 
-	return nil
+// stream returns the stream ID of a frame with the given tcp.stream in the current file
+func (fp *FrameParser) stream(fileStream int, frame *Frame) int {
+	if id, ok := fp.fileStreams[fileStream]; ok {
+		return id
+	}
+
+	id := fp.streamOffset + fileStream
+	if key := frame.connectionKey(); key != "" {
+		// the connection was already open in a previous file. A stream of
+		// the current file with the same endpoints is a new connection
+		// reusing the client port.
+		if existing, ok := fp.streams[key]; ok && existing < fp.streamOffset {
+			id = existing
+		}
+		fp.streams[key] = id
+	}
+
+	fp.fileStreams[fileStream] = id
+	if id >= fp.nextStream {
+		fp.nextStream = id + 1
+	}
+
+	return id
 }
 
 func (fp *FrameParser) parseLayers(layers Layers, index int) (*Frame, error) {
@@ -60,12 +163,36 @@ func (fp *FrameParser) parseLayers(layers Layers, index int) (*Frame, error) {
 		frame.Number = number
 	}
 
+	if val, ok := layers["ip.src"]; ok {
+		frame.SrcHost = val[0]
+	}
+
+	if val, ok := layers["ip.dst"]; ok {
+		frame.DstHost = val[0]
+	}
+
+	if val, ok := layers["tcp.srcport"]; ok {
+		port, err := strconv.Atoi(val[0])
+		if err != nil {
+			return &frame, err
+		}
+		frame.SrcPort = port
+	}
+
+	if val, ok := layers["tcp.dstport"]; ok {
+		port, err := strconv.Atoi(val[0])
+		if err != nil {
+			return &frame, err
+		}
+		frame.DstPort = port
+	}
+
 	if val, ok := layers["tcp.stream"]; ok {
 		stream, err := strconv.Atoi(val[0])
 		if err != nil {
 			return &frame, err
 		}
-		frame.TCPStream = stream
+		frame.TCPStream = fp.stream(stream, &frame)
 	}
 
 	if val, ok := layers["frame.time_relative"]; ok {
@@ -74,7 +201,21 @@ func (fp *FrameParser) parseLayers(layers Layers, index int) (*Frame, error) {
 		if err != nil {
 			return &frame, err
 		}
-		frame.TimeRelative = time.Duration(trint) * time.Nanosecond
+		frame.TimeRelative = fp.timeOffset + time.Duration(trint)*time.Nanosecond
+	}
+
+	if val, ok := layers["frame.time_epoch"]; ok {
+		t, err := parseEpoch(val[0])
+		if err != nil {
+			return &frame, err
+		}
+		frame.Time = t
+
+		// the absolute time keeps the timeline continuous across files
+		if fp.startTime.IsZero() {
+			fp.startTime = t.Add(-frame.TimeRelative)
+		}
+		frame.TimeRelative = t.Sub(fp.startTime)
 	}
 
 	if val, ok := layers["mysql.command"]; ok {
@@ -85,6 +226,14 @@ func (fp *FrameParser) parseLayers(layers Layers, index int) (*Frame, error) {
 		frame.MySQLCommand = command
 	}
 
+	if val, ok := layers["tcp.flags.syn"]; ok {
+		var err error
+		frame.TCPSyn, err = strconv.ParseBool(val[0])
+		if err != nil {
+			return &frame, err
+		}
+	}
+
 	if val, ok := layers["tcp.flags.fin"]; ok {
 		var err error
 		frame.TCPFin, err = strconv.ParseBool(val[0])
@@ -101,8 +250,23 @@ func (fp *FrameParser) parseLayers(layers Layers, index int) (*Frame, error) {
 		}
 	}
 
+	// the endpoints of a closed connection may be reused by the next one
+	if frame.TCPFin || frame.TCPReset || frame.MySQLCommand == 1 {
+		if key := frame.connectionKey(); key != "" && fp.streams[key] == frame.TCPStream {
+			delete(fp.streams, key)
+		}
+	}
+
+	if val, ok := layers["mysql.user"]; ok {
+		frame.MySQLUser = val[0]
+	}
+
 	if val, ok := layers["mysql.query"]; ok {
 		frame.MySQLQuery = NewMySQLQuery(val[0])
+		fp.Quality.Queries++
+		if _, ok := fp.unRespondedStreams[frame.TCPStream]; ok {
+			fp.Quality.Unanswered++
+		}
 		// add it to the list of unacknowledged queries
 		fp.unRespondedStreams[frame.TCPStream] = index
 
@@ -137,6 +301,20 @@ func (fp *FrameParser) parseLayers(layers Layers, index int) (*Frame, error) {
 		}
 	}
 
+	// errors are recorded on the query, the response code below records its duration
+	if val, ok := layers["mysql.error_code"]; ok && len(val) > 0 {
+		code, err := strconv.Atoi(val[0])
+		if err != nil {
+			return &frame, err
+		}
+		if idx, ok := fp.unRespondedStreams[frame.TCPStream]; ok {
+			fp.Frames[idx].MySQLQuery.ErrorCode = code
+			if message, ok := layers["mysql.error.message"]; ok && len(message) > 0 {
+				fp.Frames[idx].MySQLQuery.Error = message[0]
+			}
+		}
+	}
+
 	// select queries get a payload back
 	if val, ok := layers["mysql.payload"]; ok {
 		if len(val) > 0 {
@@ -172,12 +350,39 @@ func (fp *FrameParser) parseLayers(layers Layers, index int) (*Frame, error) {
 	}
 
 	if lost {
+		fp.Quality.LostSegments++
 		if txid, ok := fp.openTransactionStreams[frame.TCPStream]; ok {
 			// transaction got lost in the data, so remove it from the list
 			delete(fp.openTransactionStreams, frame.TCPStream)
 			fp.Transactions.Delete(txid.Index)
+			fp.Quality.LostTransactions++
 		}
 	}
 
 	return &frame, nil
 }
+
+// parseEpoch parses seconds since the epoch without losing nanoseconds to float precision
+func parseEpoch(s string) (time.Time, error) {
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+	}
+
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		if nsec, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(sec, nsec), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rawFrame(layers map[string]string) rawframe {
+	result := rawframe{Source: rawsource{Layers: make(Layers)}}
+	for k, v := range layers {
+		result.Source.Layers[k] = []string{v}
+	}
+	return result
+}
+
+func TestFrameParserAcrossFiles(t *testing.T) {
+	endpoints := map[string]string{"ip.src": "10.0.0.1", "ip.dst": "10.0.0.2", "tcp.srcport": "40000", "tcp.dstport": "3306"}
+	frame := func(layers map[string]string) rawframe {
+		for k, v := range endpoints {
+			layers[k] = v
+		}
+		return rawFrame(layers)
+	}
+
+	fp := NewFrameParser()
+
+	// the transaction starts in the first file
+	assert.NoError(t, fp.AddRawFrames([]rawframe{
+		frame(map[string]string{"tcp.stream": "3", "frame.time_relative": "1.0", "frame.time_epoch": "1700000001.000000000", "mysql.query": "BEGIN"}),
+		frame(map[string]string{"tcp.stream": "3", "frame.time_relative": "1.001", "frame.time_epoch": "1700000001.001000000", "mysql.response_code": "0"}),
+		frame(map[string]string{"tcp.stream": "3", "frame.time_relative": "1.5", "frame.time_epoch": "1700000001.500000000", "mysql.query": "SELECT 1"}),
+	}))
+
+	// and finishes in the second, where tshark numbers streams and time from zero again
+	assert.NoError(t, fp.AddRawFrames([]rawframe{
+		rawFrame(map[string]string{"tcp.stream": "0", "frame.time_relative": "0.0", "frame.time_epoch": "1700000002.000000000", "mysql.query": "SELECT 2"}),
+		frame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.1", "frame.time_epoch": "1700000002.100000000", "mysql.payload": "1"}),
+		frame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.2", "frame.time_epoch": "1700000002.200000000", "mysql.query": "COMMIT"}),
+		frame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.3", "frame.time_epoch": "1700000002.300000000", "mysql.response_code": "0"}),
+	}))
+	fp.Finish()
+
+	assert.Len(t, fp.Frames, 7)
+	// same connection, same stream
+	assert.Equal(t, 3, fp.Frames[4].TCPStream)
+	// a different connection gets a new stream
+	assert.Equal(t, 4, fp.Frames[3].TCPStream)
+
+	assert.Equal(t, 2300*time.Millisecond, fp.Frames[6].TimeRelative)
+	assert.Equal(t, 600*time.Millisecond, fp.Frames[2].MySQLQuery.Duration)
+
+	assert.Len(t, fp.Transactions.Transactions, 1)
+	transaction := fp.Transactions.Transactions[0]
+	assert.Len(t, transaction.Frames, 3)
+	assert.Equal(t, 1300*time.Millisecond, transaction.TotalDuration())
+}
+
+func TestFrameParserReusedPort(t *testing.T) {
+	frame := func(layers map[string]string) rawframe {
+		layers["ip.src"], layers["ip.dst"], layers["tcp.srcport"], layers["tcp.dstport"] = "10.0.0.1", "10.0.0.2", "40000", "3306"
+		return rawFrame(layers)
+	}
+
+	fp := NewFrameParser()
+
+	// two connections from the same client port in one file
+	assert.NoError(t, fp.AddRawFrames([]rawframe{
+		frame(map[string]string{"tcp.stream": "0", "frame.time_relative": "0.0", "mysql.query": "SELECT 1"}),
+		frame(map[string]string{"tcp.stream": "0", "frame.time_relative": "0.1", "mysql.payload": "1"}),
+		frame(map[string]string{"tcp.stream": "2", "frame.time_relative": "1.0", "mysql.query": "SELECT 2"}),
+		frame(map[string]string{"tcp.stream": "2", "frame.time_relative": "1.1", "mysql.payload": "1"}),
+		frame(map[string]string{"tcp.stream": "2", "frame.time_relative": "1.2", "mysql.command": "1"}),
+	}))
+
+	// and a third in the next file, after the second quit
+	assert.NoError(t, fp.AddRawFrames([]rawframe{
+		frame(map[string]string{"tcp.stream": "0", "frame.time_relative": "0.0", "mysql.query": "SELECT 3"}),
+	}))
+	fp.Finish()
+
+	assert.Equal(t, 0, fp.Frames[0].TCPStream)
+	assert.Equal(t, 2, fp.Frames[2].TCPStream)
+	assert.Equal(t, 2, fp.Frames[4].TCPStream)
+	assert.Equal(t, 3, fp.Frames[5].TCPStream)
+}
+
+func TestNaturalLess(t *testing.T) {
+	assert.True(t, naturalLess("mysql.pcap2", "mysql.pcap10"))
+	assert.False(t, naturalLess("mysql.pcap10", "mysql.pcap2"))
+	assert.True(t, naturalLess("a-20220411-1200.pcap", "a-20220411-1215.pcap"))
+	assert.True(t, naturalLess("a.pcap", "a.pcap1"))
+}
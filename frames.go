@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"net"
+	"strconv"
+	"time"
+)
 
 type Layers map[string][]string
 
@@ -9,47 +13,48 @@ type Frames []*Frame
 type Frame struct {
 	Number       int
 	TimeRelative time.Duration
+	// Time is the absolute time of the frame, if frame.time_epoch was captured
+	Time         time.Time
+	SrcHost      string
+	SrcPort      int
+	DstHost      string
+	DstPort      int
 	TCPStream    int
+	TCPSyn       bool
 	TCPFin       bool
 	TCPReset     bool
 	MySQLCommand int
 	MySQLQuery   MySQLQuery
+	// MySQLUser is the user of a login request
+	MySQLUser string
 }
 
-func (f *Frames) CountByTag() map[string]int {
-	result := make(map[string]int)
-	for _, frame := range *f {
-		for k, v := range frame.MySQLQuery.Tags {
-			result[k+":"+v] += 1
-		}
+// Src returns the host:port the frame was sent from, empty if not captured
+func (f *Frame) Src() string {
+	if f.SrcHost == "" || f.SrcPort == 0 {
+		return ""
 	}
-	return result
+	return net.JoinHostPort(f.SrcHost, strconv.Itoa(f.SrcPort))
 }
 
-func (f *Frames) QueriesForTag(key, value string) map[string]int {
-	result := make(map[string]int)
-
-	for _, frame := range *f {
-		if frame.MySQLQuery.Tags[key] == value {
-			result[frame.MySQLQuery.Fingerprint] += 1
-		}
+// Dst returns the host:port the frame was sent to, empty if not captured
+func (f *Frame) Dst() string {
+	if f.DstHost == "" || f.DstPort == 0 {
+		return ""
 	}
-
-	return result
+	return net.JoinHostPort(f.DstHost, strconv.Itoa(f.DstPort))
 }
 
-func (f *Frames) TagsForFingerprint(fingerprint string) map[string]int {
-	result := make(map[string]int)
-
-	for _, frame := range *f {
-		if frame.MySQLQuery.Fingerprint != fingerprint {
-			continue
-		}
-
-		for k, v := range frame.MySQLQuery.Tags {
-			result[k+":"+v] += 1
-		}
+// connectionKey identifies the TCP connection of the frame by its endpoints,
+// the same in both directions. It is empty if the endpoints were not captured
+func (f *Frame) connectionKey() string {
+	src, dst := f.Src(), f.Dst()
+	if src == "" || dst == "" {
+		return ""
 	}
 
-	return result
+	if src > dst {
+		src, dst = dst, src
+	}
+	return src + " " + dst
 }
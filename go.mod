@@ -4,7 +4,6 @@ go 1.17
 
 require (
 	github.com/davecgh/go-spew v1.1.1
-	github.com/montanaflynn/stats v0.6.6
 	github.com/percona/go-mysql v0.0.0-20210427141028-73d29c6da78c
 )
 
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultRelativeError is the relative error of histogram quantiles unless configured otherwise
+const DefaultRelativeError = 0.01
+
+// Histogram is a mergeable, log-bucketed (DDSketch-style) latency histogram.
+// Every quantile it reports is within RelativeError of the true value, and
+// its size depends on the range of the durations, not on how many were added:
+// one nanosecond to one hour fits in ~1500 buckets at 1% relative error.
+type Histogram struct {
+	RelativeError float64 `json:"relative_error"`
+	// Buckets counts durations by bucket index, where bucket i covers
+	// (gamma^(i-1), gamma^i] nanoseconds
+	Buckets map[int]int `json:"buckets"`
+	// Zero counts durations <= 0 (e.g. queries that never saw a response)
+	Zero  int           `json:"zero"`
+	Count int           `json:"count"`
+	Sum   time.Duration `json:"sum"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+
+	gamma    float64
+	logGamma float64
+}
+
+func NewHistogram(relativeError float64) *Histogram {
+	h := &Histogram{
+		RelativeError: relativeError,
+		Buckets:       make(map[int]int),
+	}
+	h.init()
+	return h
+}
+
+func (h *Histogram) init() {
+	h.gamma = (1 + h.RelativeError) / (1 - h.RelativeError)
+	h.logGamma = math.Log(h.gamma)
+	if h.Buckets == nil {
+		h.Buckets = make(map[int]int)
+	}
+}
+
+func (h *Histogram) Add(d time.Duration) {
+	if h.Count == 0 || d < h.Min {
+		h.Min = d
+	}
+	if h.Count == 0 || d > h.Max {
+		h.Max = d
+	}
+	h.Count++
+	h.Sum += d
+
+	if d <= 0 {
+		h.Zero++
+		return
+	}
+	h.Buckets[h.index(d)]++
+}
+
+// Merge adds all the durations recorded in other to h
+func (h *Histogram) Merge(other *Histogram) error {
+	if other.Count == 0 {
+		return nil
+	}
+	if h.RelativeError != other.RelativeError {
+		return fmt.Errorf("cannot merge histograms with relative error %v and %v", h.RelativeError, other.RelativeError)
+	}
+
+	if h.Count == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if h.Count == 0 || other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+	h.Zero += other.Zero
+	for idx, count := range other.Buckets {
+		h.Buckets[idx] += count
+	}
+
+	return nil
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the duration at quantile q, between 0 and 1
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(q * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	seen := h.Zero
+	if seen >= rank {
+		return h.clamp(0)
+	}
+
+	for _, idx := range h.indexes() {
+		seen += h.Buckets[idx]
+		if seen >= rank {
+			return h.clamp(h.value(idx))
+		}
+	}
+
+	return h.Max
+}
+
+// CountAtOrBelow returns approximately how many durations are <= d
+func (h *Histogram) CountAtOrBelow(d time.Duration) int {
+	if d < 0 {
+		return 0
+	}
+
+	result := h.Zero
+	if d == 0 {
+		return result
+	}
+
+	limit := h.index(d)
+	for idx, count := range h.Buckets {
+		if idx <= limit {
+			result += count
+		}
+	}
+	return result
+}
+
+// index returns the bucket index for a positive duration
+func (h *Histogram) index(d time.Duration) int {
+	return int(math.Ceil(math.Log(float64(d)) / h.logGamma))
+}
+
+// value returns the representative duration of a bucket, which is within
+// the relative error of everything in it
+func (h *Histogram) value(idx int) time.Duration {
+	return time.Duration(2 * math.Pow(h.gamma, float64(idx)) / (h.gamma + 1))
+}
+
+func (h *Histogram) clamp(d time.Duration) time.Duration {
+	if d < h.Min {
+		return h.Min
+	}
+	if d > h.Max {
+		return h.Max
+	}
+	return d
+}
+
+func (h *Histogram) indexes() []int {
+	result := make([]int, 0, len(h.Buckets))
+	for idx := range h.Buckets {
+		result = append(result, idx)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// UnmarshalJSON restores the derived bucket parameters of a serialized histogram
+func (h *Histogram) UnmarshalJSON(b []byte) error {
+	type histogram Histogram
+	var data histogram
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if data.RelativeError <= 0 || data.RelativeError >= 1 {
+		return errors.New("histogram relative error must be between 0 and 1")
+	}
+
+	*h = Histogram(data)
+	h.init()
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram(0.01)
+	for i := 1; i <= 1000; i++ {
+		h.Add(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		quantile float64
+		expected time.Duration
+	}{
+		{quantile: 0, expected: 1 * time.Millisecond},
+		{quantile: 0.5, expected: 500 * time.Millisecond},
+		{quantile: 0.99, expected: 990 * time.Millisecond},
+		{quantile: 0.999, expected: 999 * time.Millisecond},
+		{quantile: 1, expected: 1000 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		actual := h.Quantile(test.quantile)
+		assert.InEpsilon(t, float64(test.expected), float64(actual), 0.01, "quantile %v", test.quantile)
+	}
+
+	assert.Equal(t, 1000, h.Count)
+	assert.Equal(t, 1*time.Millisecond, h.Min)
+	assert.Equal(t, 1000*time.Millisecond, h.Max)
+	assert.Equal(t, 500500*time.Millisecond, h.Sum)
+	assert.Less(t, len(h.Buckets), 400)
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a := NewHistogram(0.01)
+	b := NewHistogram(0.01)
+	all := NewHistogram(0.01)
+	for i := 0; i <= 100; i++ {
+		d := time.Duration(i) * time.Microsecond
+		if i%2 == 0 {
+			a.Add(d)
+		} else {
+			b.Add(d)
+		}
+		all.Add(d)
+	}
+
+	// round trip through JSON like a summary from another run
+	data, err := json.Marshal(b)
+	assert.NoError(t, err)
+	var decoded Histogram
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+
+	assert.NoError(t, a.Merge(&decoded))
+	assert.Equal(t, all, a)
+
+	coarse := NewHistogram(0.05)
+	coarse.Add(time.Second)
+	assert.Error(t, a.Merge(coarse))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// IdleReport aggregates the idle-in-transaction gaps of a capture by the
+// statements around them and keeps the biggest individual gaps
+type IdleReport struct {
+	config StatisticsConfig
+	top    int
+	groups map[string]*IdleGroup
+	// Gaps are the biggest gaps, biggest first
+	Gaps []Gap
+}
+
+// IdleGroup is every gap of one kind between the same pair of statements
+type IdleGroup struct {
+	Kind      string
+	Before    string
+	After     string
+	Durations *Histogram
+	// Tags counts the gaps by the tags of the statements around them
+	Tags map[string]int
+}
+
+// NewIdleReport keeps the top biggest individual gaps
+func NewIdleReport(config StatisticsConfig, top int) IdleReport {
+	return IdleReport{config: config, top: top, groups: make(map[string]*IdleGroup)}
+}
+
+func (ir *IdleReport) Add(t *Transaction) {
+	for _, gap := range t.Gaps() {
+		key := gap.Kind + "\n" + gap.Before.MySQLQuery.Fingerprint + "\n" + gap.After.MySQLQuery.Fingerprint
+		group, ok := ir.groups[key]
+		if !ok {
+			group = &IdleGroup{
+				Kind:      gap.Kind,
+				Before:    gap.Before.MySQLQuery.Fingerprint,
+				After:     gap.After.MySQLQuery.Fingerprint,
+				Durations: NewHistogram(ir.config.RelativeError),
+				Tags:      make(map[string]int),
+			}
+			ir.groups[key] = group
+		}
+
+		group.Durations.Add(gap.Duration)
+		for k, v := range gap.Tags() {
+			group.Tags[k+":"+v]++
+		}
+
+		ir.addGap(gap)
+	}
+}
+
+// addGap keeps gap if it is one of the biggest
+func (ir *IdleReport) addGap(gap Gap) {
+	if ir.top <= 0 {
+		return
+	}
+	if len(ir.Gaps) == ir.top && gap.Duration <= ir.Gaps[len(ir.Gaps)-1].Duration {
+		return
+	}
+
+	idx := sort.Search(len(ir.Gaps), func(i int) bool {
+		return ir.Gaps[i].Duration < gap.Duration
+	})
+	ir.Gaps = append(ir.Gaps, Gap{})
+	copy(ir.Gaps[idx+1:], ir.Gaps[idx:])
+	ir.Gaps[idx] = gap
+
+	if len(ir.Gaps) > ir.top {
+		ir.Gaps = ir.Gaps[:ir.top]
+	}
+}
+
+// Groups returns the groups ordered by total idle time, most first
+func (ir *IdleReport) Groups() []*IdleGroup {
+	result := make([]*IdleGroup, 0, len(ir.groups))
+	for _, group := range ir.groups {
+		result = append(result, group)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Durations.Sum != result[j].Durations.Sum {
+			return result[i].Durations.Sum > result[j].Durations.Sum
+		}
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		if result[i].Before != result[j].Before {
+			return result[i].Before < result[j].Before
+		}
+		return result[i].After < result[j].After
+	})
+
+	return result
+}
+
+func (ir *IdleReport) MarshalJSON() ([]byte, error) {
+	type group struct {
+		Kind     string         `json:"kind"`
+		Before   string         `json:"before"`
+		After    string         `json:"after"`
+		Duration TimeStatistics `json:"duration"`
+		Tags     map[string]int `json:"tags"`
+	}
+
+	type gap struct {
+		Kind     string            `json:"kind"`
+		Duration float64           `json:"duration"`
+		Stream   int               `json:"stream"`
+		Time     float64           `json:"time"`
+		Before   string            `json:"before"`
+		After    string            `json:"after"`
+		Tags     map[string]string `json:"tags"`
+	}
+
+	data := struct {
+		Groups []group `json:"groups"`
+		Gaps   []gap   `json:"biggest_gaps"`
+	}{
+		Groups: []group{},
+		Gaps:   []gap{},
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+
+	for _, g := range ir.Groups() {
+		ts, err := NewTimeStatistics(g.Durations, ir.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+		data.Groups = append(data.Groups, group{Kind: g.Kind, Before: g.Before, After: g.After, Duration: ts, Tags: g.Tags})
+	}
+
+	for _, g := range ir.Gaps {
+		data.Gaps = append(data.Gaps, gap{
+			Kind:     g.Kind,
+			Duration: ms(g.Duration),
+			Stream:   g.Before.TCPStream,
+			Time:     ms(g.Before.TimeRelative + g.Before.MySQLQuery.Duration),
+			Before:   g.Before.MySQLQuery.Query,
+			After:    g.After.MySQLQuery.Query,
+			Tags:     g.Tags(),
+		})
+	}
+
+	return json.Marshal(data)
+}
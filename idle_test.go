@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdleReport(t *testing.T) {
+	_, transactions := buildTransactions(t, 1, [][]timedQuery{{
+		{"BEGIN", 0},
+		{"SELECT 1 FROM foo /*controller:bar*/", 10 * time.Millisecond},
+		{"UPDATE foo SET a = 1 /*controller:bar*/", 100 * time.Millisecond},
+		{"COMMIT", 130 * time.Millisecond},
+	}})
+	transaction := transactions.Transactions[0]
+
+	gaps := transaction.Gaps()
+	assert.Len(t, gaps, 3)
+	assert.Equal(t, GapAfterBegin, gaps[0].Kind)
+	assert.Equal(t, 9*time.Millisecond, gaps[0].Duration)
+	assert.Equal(t, GapBetween, gaps[1].Kind)
+	assert.Equal(t, 89*time.Millisecond, gaps[1].Duration)
+	assert.Equal(t, GapBeforeCommit, gaps[2].Kind)
+	assert.Equal(t, 29*time.Millisecond, gaps[2].Duration)
+	assert.Equal(t, map[string]string{"controller": "bar"}, gaps[1].Tags())
+
+	var total time.Duration
+	for _, gap := range gaps {
+		total += gap.Duration
+	}
+	assert.Equal(t, transaction.WasteDuration(), total)
+
+	report := NewIdleReport(DefaultStatisticsConfig(), 2)
+	report.Add(transaction)
+
+	assert.Len(t, report.Gaps, 2)
+	assert.Equal(t, 89*time.Millisecond, report.Gaps[0].Duration)
+	assert.Equal(t, 29*time.Millisecond, report.Gaps[1].Duration)
+
+	groups := report.Groups()
+	assert.Len(t, groups, 3)
+	assert.Equal(t, GapBetween, groups[0].Kind)
+	assert.Equal(t, "select ? from foo", groups[0].Before)
+	assert.Equal(t, 1, groups[0].Tags["controller:bar"])
+}
+
+func TestGapsEmptyTransaction(t *testing.T) {
+	_, transactions := buildTransactions(t, 1, [][]timedQuery{{{"BEGIN", 0}, {"COMMIT", 20 * time.Millisecond}}})
+
+	gaps := transactions.Transactions[0].Gaps()
+	assert.Len(t, gaps, 1)
+	assert.Equal(t, GapEmpty, gaps[0].Kind)
+	assert.Equal(t, 19*time.Millisecond, gaps[0].Duration)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TsharkFilter keeps the mysql frames and the TCP handshakes and teardowns,
+// which carry no mysql payload but tell when connections open and close
+const TsharkFilter = "mysql || tcp.flags.syn==1 || tcp.flags.fin==1 || tcp.flags.reset==1"
+
+// TsharkFields are the fields the analyzer reads from the tshark JSON output
+var TsharkFields = []string{
+	"tcp.flags.syn",
+	"tcp.flags.fin",
+	"tcp.flags.reset",
+	"tcp.analysis.lost_segment",
+	"tcp.analysis.ack_lost_segment",
+	"frame.number",
+	"frame.time_relative",
+	"frame.time_epoch",
+	"ip.src",
+	"ip.dst",
+	"tcp.srcport",
+	"tcp.dstport",
+	"tcp.stream",
+	"mysql.command",
+	"mysql.query",
+	"mysql.payload",
+	"mysql.response_code",
+	"mysql.user",
+	"mysql.error_code",
+	"mysql.error.message",
+}
+
+type rawsource struct {
+	Layers Layers `json:"layers"`
+}
+
+type rawframe struct {
+	Source rawsource `json:"_source"`
+}
+
+// readRawFrames decodes the tshark JSON output of one capture
+func readRawFrames(r io.Reader) ([]rawframe, error) {
+	var rawframes []rawframe
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	for {
+		if err := dec.Decode(&rawframes); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return rawframes, nil
+}
+
+// readCapture reads the frames of a capture file. JSON files are expected to be
+// tshark output already, anything else is run through tshark
+func readCapture(path string) ([]rawframe, error) {
+	if strings.HasSuffix(path, ".json") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		rawframes, err := readRawFrames(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return rawframes, nil
+	}
+
+	args := []string{"-r", path, "-Y", TsharkFilter, "-Tjson"}
+	for _, field := range TsharkFields {
+		args = append(args, "-e", field)
+	}
+
+	cmd := exec.Command("tshark", args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	rawframes, err := readRawFrames(stdout)
+	if err != nil {
+		cmd.Wait()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("tshark %s: %w", path, err)
+	}
+
+	return rawframes, nil
+}
+
+// CaptureFiles returns the capture files in a directory or matching a glob,
+// in capture order. Names must sort in capture order, numbers in them are
+// compared numerically so mysql.pcap2 comes before mysql.pcap10
+func CaptureFiles(pattern string) ([]string, error) {
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+			files = append(files, match)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return naturalLess(files[i], files[j])
+	})
+
+	return files, nil
+}
+
+// CaptureKey identifies a version of a capture file by its path, modification
+// time and size, "-" for stdin
+func CaptureKey(path string) (string, error) {
+	if path == "-" {
+		return path, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s@%s#%d", path, info.ModTime().String(), info.Size()), nil
+}
+
+// FollowCaptures processes the capture files matching pattern in order, then
+// keeps polling for new ones until stop is closed. The newest file is still
+// being written by tcpdump, so it is only processed once a newer file shows
+// up or when following stops.
+func FollowCaptures(pattern string, interval time.Duration, stop <-chan struct{}, process func(path string) error) error {
+	// keyed by CaptureKey, so files rewritten by a tcpdump ring buffer are processed again
+	done := make(map[string]bool)
+
+	processFiles := func(includeNewest bool) error {
+		files, err := CaptureFiles(pattern)
+		if err != nil {
+			return err
+		}
+		if !includeNewest && len(files) > 0 {
+			files = files[:len(files)-1]
+		}
+
+		for _, file := range files {
+			key, err := CaptureKey(file)
+			if err != nil {
+				return err
+			}
+			if done[key] {
+				continue
+			}
+
+			if err := process(file); err != nil {
+				return err
+			}
+			done[key] = true
+		}
+		return nil
+	}
+
+	for {
+		if err := processFiles(false); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return processFiles(true)
+		case <-time.After(interval):
+		}
+	}
+}
+
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ca, cb := a[0], b[0]
+		if isDigit(ca) && isDigit(cb) {
+			na, ra := leadingDigits(a)
+			nb, rb := leadingDigits(b)
+			// compare the numbers by length first, ignoring leading zeros
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			a, b = ra, rb
+			continue
+		}
+
+		if ca != cb {
+			return ca < cb
+		}
+		a, b = a[1:], b[1:]
+	}
+
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func leadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// KeyspaceMap maps tables to the keyspace or shard group they live in
+type KeyspaceMap struct {
+	tables map[string]string
+}
+
+// ReadKeyspaceMap reads a JSON object of table to keyspace. Tables may be
+// qualified with their database, and "*" is the keyspace of every other table.
+func ReadKeyspaceMap(path string) (KeyspaceMap, error) {
+	m := KeyspaceMap{tables: make(map[string]string)}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	var tables map[string]string
+	if err := json.Unmarshal(b, &tables); err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// fingerprints are lower case
+	for table, keyspace := range tables {
+		if keyspace == "" {
+			return m, fmt.Errorf("%s: table %q has no keyspace", path, table)
+		}
+		m.tables[strings.ToLower(table)] = keyspace
+	}
+
+	return m, nil
+}
+
+// Keyspace returns the keyspace of the table, or "" if it is not mapped
+func (m *KeyspaceMap) Keyspace(table string) string {
+	if keyspace, ok := m.tables[table]; ok {
+		return keyspace
+	}
+	// db.table falls back to the unqualified table
+	if idx := strings.LastIndex(table, "."); idx >= 0 {
+		if keyspace, ok := m.tables[table[idx+1:]]; ok {
+			return keyspace
+		}
+	}
+	return m.tables["*"]
+}
+
+// CrossKeyspaceReport lists the normalized transactions touching more than
+// one keyspace, which block splitting the keyspaces apart
+type CrossKeyspaceReport struct {
+	config    StatisticsConfig
+	keyspaces KeyspaceMap
+	nts       NormalizedTransactions
+	// statements are the statements of each normalized transaction, keyed like nts
+	statements map[string][]crossKeyspaceStatement
+	// Unmapped lists the tables without a keyspace, which are left out
+	Unmapped []string
+}
+
+type crossKeyspaceStatement struct {
+	Fingerprint string   `json:"fingerprint"`
+	Keyspaces   []string `json:"keyspaces"`
+	Tables      []string `json:"tables"`
+}
+
+func NewCrossKeyspaceReport(config StatisticsConfig, keyspaces KeyspaceMap, transactions Transactions) CrossKeyspaceReport {
+	report := CrossKeyspaceReport{
+		config:     config,
+		keyspaces:  keyspaces,
+		nts:        NewNormalizedTransactions(config),
+		statements: make(map[string][]crossKeyspaceStatement),
+	}
+
+	parsed := make(StatementCache)
+	unmapped := make(map[string]bool)
+	for _, t := range sortedTransactions(transactions) {
+		var statements []crossKeyspaceStatement
+		touched := make(map[string]bool)
+		for _, frame := range t.Frames {
+			fingerprint := frame.MySQLQuery.Fingerprint
+			statement := parsed.Parse(fingerprint)
+			tables := statement.Tables()
+			if len(tables) == 0 {
+				continue
+			}
+			statementKeyspaces := make(map[string]bool)
+			for _, table := range tables {
+				keyspace := keyspaces.Keyspace(table)
+				if keyspace == "" {
+					unmapped[table] = true
+					continue
+				}
+				statementKeyspaces[keyspace] = true
+				touched[keyspace] = true
+			}
+			statements = append(statements, crossKeyspaceStatement{
+				Fingerprint: fingerprint,
+				Keyspaces:   sortedKeys(statementKeyspaces),
+				Tables:      tables,
+			})
+		}
+
+		if len(touched) < 2 {
+			continue
+		}
+		report.nts.Add(*t)
+		key := strings.Join(t.FingerprintWith(config.FingerprintStrategy), "\n") + "\n"
+		if _, ok := report.statements[key]; !ok {
+			report.statements[key] = statements
+		}
+	}
+	report.Unmapped = sortedKeys(unmapped)
+
+	return report
+}
+
+func (r *CrossKeyspaceReport) MarshalJSON() ([]byte, error) {
+	type transaction struct {
+		ID                    string                   `json:"id"`
+		Fingerprint           []string                 `json:"fingerprint"`
+		Keyspaces             map[string][]string      `json:"keyspaces"`
+		Statements            []crossKeyspaceStatement `json:"statements"`
+		Transactions          int                      `json:"transactions"`
+		TransactionStatistics TimeStatistics           `json:"transaction_statistics"`
+		Tags                  []string                 `json:"tags"`
+	}
+
+	keys := make([]string, 0, len(r.nts.Transactions))
+	for key := range r.nts.Transactions {
+		keys = append(keys, key)
+	}
+	// the most frequent first
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := r.nts.Transactions[keys[i]], r.nts.Transactions[keys[j]]
+		if a.transactionDurations.Count != b.transactionDurations.Count {
+			return a.transactionDurations.Count > b.transactionDurations.Count
+		}
+		return keys[i] < keys[j]
+	})
+
+	result := []transaction{}
+	for _, key := range keys {
+		nt := r.nts.Transactions[key]
+		ts, err := NewTimeStatistics(nt.transactionDurations, r.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := transaction{
+			ID:                    nt.ID(),
+			Fingerprint:           nt.Fingerprint,
+			Keyspaces:             make(map[string][]string),
+			Statements:            r.statements[key],
+			Transactions:          nt.transactionDurations.Count,
+			TransactionStatistics: ts,
+		}
+		tables := make(map[string]map[string]bool)
+		for _, statement := range entry.Statements {
+			for _, table := range statement.Tables {
+				keyspace := r.keyspaces.Keyspace(table)
+				if keyspace == "" {
+					continue
+				}
+				if tables[keyspace] == nil {
+					tables[keyspace] = make(map[string]bool)
+				}
+				tables[keyspace][table] = true
+			}
+		}
+		for keyspace, names := range tables {
+			entry.Keyspaces[keyspace] = sortedKeys(names)
+		}
+		for tag := range nt.tags {
+			entry.Tags = append(entry.Tags, tag)
+		}
+		sort.Strings(entry.Tags)
+
+		result = append(result, entry)
+	}
+
+	return json.Marshal(struct {
+		Transactions []transaction `json:"transactions"`
+		Unmapped     []string      `json:"unmapped_tables"`
+	}{result, r.Unmapped})
+}
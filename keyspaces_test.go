@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrossKeyspaceReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyspaces.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"Users": "main", "repos": "main", "audit_log": "audit"}`), 0644))
+	keyspaces, err := ReadKeyspaceMap(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", keyspaces.Keyspace("users"))
+	assert.Equal(t, "main", keyspaces.Keyspace("github.repos"))
+	assert.Equal(t, "", keyspaces.Keyspace("other"))
+
+	_, transactions := buildTransactions(t, 1, [][]timedQuery{
+		{
+			{"BEGIN", 0},
+			{"UPDATE users SET name = 'a' WHERE id = 1 /*controller:users*/", time.Millisecond},
+			{"INSERT INTO audit_log VALUES (1)", 2 * time.Millisecond},
+			{"COMMIT", 3 * time.Millisecond},
+		},
+		{
+			{"BEGIN", 0},
+			{"UPDATE users SET name = 'a' WHERE id = 1", time.Millisecond},
+			{"UPDATE repos SET name = 'b' WHERE id = 1", 2 * time.Millisecond},
+			{"INSERT INTO other VALUES (1)", 3 * time.Millisecond},
+			{"COMMIT", 4 * time.Millisecond},
+		},
+	})
+
+	report := NewCrossKeyspaceReport(DefaultStatisticsConfig(), keyspaces, transactions)
+	assert.Len(t, report.nts.Transactions, 1)
+	assert.Equal(t, []string{"other"}, report.Unmapped)
+	for key, nt := range report.nts.Transactions {
+		assert.Equal(t, []string{"begin", "update users set name = ? where id = ?", "insert into audit_log values(?+)", "commit"}, nt.Fingerprint)
+		statements := report.statements[key]
+		assert.Len(t, statements, 2)
+		assert.Equal(t, []string{"audit"}, statements[1].Keyspaces)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LiteralsConfig configures the literal value report
+type LiteralsConfig struct {
+	// TopK is the number of most frequent values reported per placeholder
+	TopK int
+	// Skew is the share of calls one value must make up to be reported as skewed
+	Skew float64
+	// Redact replaces the values with a hash of them
+	Redact bool
+}
+
+func DefaultLiteralsConfig() LiteralsConfig {
+	return LiteralsConfig{TopK: 10, Skew: 0.3}
+}
+
+// TopK approximately counts the most frequent values of a stream with the
+// space-saving algorithm: a value that is not counted replaces the least
+// frequent one, inheriting its count as the possible overestimate.
+type TopK struct {
+	capacity int
+	counters topKHeap
+	// Total is the number of values added
+	Total int
+}
+
+type TopKCounter struct {
+	Value string
+	Count int
+	// Error is how much Count may overestimate the value
+	Error int
+}
+
+// NewTopK counts up to capacity values, at least one
+func NewTopK(capacity int) *TopK {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TopK{capacity: capacity, counters: topKHeap{index: make(map[string]int)}}
+}
+
+func (t *TopK) Add(value string) {
+	t.Total++
+	if idx, ok := t.counters.index[value]; ok {
+		t.counters.counters[idx].Count++
+		heap.Fix(&t.counters, idx)
+		return
+	}
+	if t.counters.Len() < t.capacity {
+		heap.Push(&t.counters, &TopKCounter{Value: value, Count: 1})
+		return
+	}
+
+	// the least frequent value is at the root
+	min := t.counters.counters[0]
+	delete(t.counters.index, min.Value)
+	t.counters.counters[0] = &TopKCounter{Value: value, Count: min.Count + 1, Error: min.Count}
+	t.counters.index[value] = 0
+	heap.Fix(&t.counters, 0)
+}
+
+// Top returns the k most frequent values, most first
+func (t *TopK) Top(k int) []TopKCounter {
+	result := make([]TopKCounter, 0, t.counters.Len())
+	for _, counter := range t.counters.counters {
+		result = append(result, *counter)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
+
+// topKHeap is a min-heap of counters by count, then value, with the
+// position of each value in it
+type topKHeap struct {
+	counters []*TopKCounter
+	index    map[string]int
+}
+
+func (h *topKHeap) Len() int {
+	return len(h.counters)
+}
+
+func (h *topKHeap) Less(i, j int) bool {
+	if h.counters[i].Count != h.counters[j].Count {
+		return h.counters[i].Count < h.counters[j].Count
+	}
+	return h.counters[i].Value < h.counters[j].Value
+}
+
+func (h *topKHeap) Swap(i, j int) {
+	h.counters[i], h.counters[j] = h.counters[j], h.counters[i]
+	h.index[h.counters[i].Value] = i
+	h.index[h.counters[j].Value] = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	counter := x.(*TopKCounter)
+	h.index[counter.Value] = len(h.counters)
+	h.counters = append(h.counters, counter)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	counter := h.counters[len(h.counters)-1]
+	h.counters = h.counters[:len(h.counters)-1]
+	delete(h.index, counter.Value)
+	return counter
+}
+
+// Literal is a literal value of a query and the placeholder it fills
+type Literal struct {
+	// Position is the placeholder number, starting at 1. The values of an IN
+	// list share one placeholder, and so do the rows of VALUES.
+	Position int
+	// Column is the column the value is compared to or inserted into, if known
+	Column string
+	Value  string
+}
+
+// ExtractLiterals returns the number and string literals of a query in order
+func ExtractLiterals(query string) []Literal {
+	var result []Literal
+	position := 0
+	// listPosition is the placeholder of the IN list being read, valuesStart
+	// the placeholder before the first column of VALUES
+	listPosition, listDepth, listColumn := 0, 0, ""
+	valuesStart, valuesDepth, valuesColumn := -1, 0, 0
+	depth := 0
+	var previous []string // the last words and operators, most recent last
+	var insertColumns []string
+	var columns []string // the column list after INSERT INTO table
+
+	push := func(token string) {
+		previous = append(previous, strings.ToLower(token))
+		if len(previous) > 3 {
+			previous = previous[1:]
+		}
+	}
+	last := func(n int) string {
+		if len(previous) < n {
+			return ""
+		}
+		return previous[len(previous)-n]
+	}
+	add := func(value string) {
+		literal := Literal{Value: value}
+		switch {
+		case listDepth > 0:
+			literal.Position = listPosition
+			if listPosition == 0 {
+				position++
+				listPosition = position
+				literal.Position = position
+			}
+			literal.Column = listColumn
+		case valuesStart >= 0 && depth == valuesDepth+1:
+			valuesColumn++
+			literal.Position = valuesStart + valuesColumn
+			if literal.Position > position {
+				position = literal.Position
+			}
+			if valuesColumn <= len(insertColumns) {
+				literal.Column = insertColumns[valuesColumn-1]
+			}
+		default:
+			position++
+			literal.Position = position
+			if last(1) == "=" || last(1) == "<" || last(1) == ">" || last(1) == "like" {
+				literal.Column = trimQualifier(last(2))
+			}
+		}
+		result = append(result, literal)
+		push("?")
+	}
+
+	for idx := 0; idx < len(query); {
+		c := query[idx]
+		switch {
+		case c == '\'' || c == '"':
+			end := idx + 1
+			var value strings.Builder
+			for end < len(query) && query[end] != c {
+				if query[end] == '\\' && end+1 < len(query) {
+					end++
+				}
+				value.WriteByte(query[end])
+				end++
+			}
+			add(value.String())
+			idx = end + 1
+		case c == '`':
+			end := strings.IndexByte(query[idx+1:], '`')
+			if end < 0 {
+				return result
+			}
+			word := query[idx+1 : idx+1+end]
+			push(word)
+			if columns != nil {
+				columns = append(columns, strings.ToLower(word))
+			}
+			idx += end + 2
+		case c >= '0' && c <= '9' || c == '-' && idx+1 < len(query) && query[idx+1] >= '0' && query[idx+1] <= '9' && !isOperand(last(1)):
+			end := idx + 1
+			for end < len(query) && (query[end] >= '0' && query[end] <= '9' || query[end] == '.') {
+				end++
+			}
+			add(query[idx:end])
+			idx = end
+		case isIdentifierByte(c):
+			end := idx
+			for end < len(query) && (isIdentifierByte(query[end]) || query[end] >= '0' && query[end] <= '9' || query[end] == '.') {
+				end++
+			}
+			word := query[idx:end]
+			switch strings.ToLower(word) {
+			case "values", "value":
+				valuesStart, valuesDepth = position, depth
+				insertColumns, columns = columns, nil
+			case "null", "true", "false":
+				add(strings.ToLower(word))
+				idx = end
+				continue
+			}
+			push(word)
+			if columns != nil {
+				columns = append(columns, strings.ToLower(word))
+			}
+			idx = end
+		case c == '(':
+			depth++
+			if last(1) == "in" {
+				listDepth, listPosition, listColumn = depth, 0, trimQualifier(last(2))
+			}
+			if last(2) == "into" && columns == nil && valuesStart < 0 {
+				columns = []string{}
+			}
+			if valuesStart >= 0 && depth == valuesDepth+1 {
+				valuesColumn = 0
+			}
+			push("(")
+			idx++
+		case c == ')':
+			if depth == listDepth {
+				listDepth = 0
+			}
+			depth--
+			push(")")
+			idx++
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			idx++
+		default:
+			end := idx + 1
+			for end < len(query) && strings.IndexByte("=<>!", query[end]) >= 0 && strings.IndexByte("=<>!", c) >= 0 {
+				end++
+			}
+			push(query[idx:end])
+			idx = end
+		}
+	}
+
+	return result
+}
+
+func trimQualifier(column string) string {
+	if dot := strings.LastIndex(column, "."); dot >= 0 {
+		return column[dot+1:]
+	}
+	if column == "" || !isIdentifierByte(column[0]) {
+		return ""
+	}
+	return column
+}
+
+func isIdentifierByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' || c >= 0x80
+}
+
+// isOperand tells whether a token ends an operand, making a following - a minus
+func isOperand(token string) bool {
+	return token == "?" || token == ")" || token != "" && isIdentifierByte(token[0]) && token != "in" && token != "and" && token != "or" && token != "where" && token != "values" && token != "value"
+}
+
+// LiteralsReport keeps the distribution of the literal values of each
+// placeholder of each fingerprint
+type LiteralsReport struct {
+	config LiteralsConfig
+	// key is the random key values are redacted with
+	key []byte
+	// placeholders is keyed by fingerprint and position
+	placeholders map[string]*Placeholder
+}
+
+// Placeholder is one placeholder of a fingerprint and its values
+type Placeholder struct {
+	Fingerprint string
+	Position    int
+	Column      string
+	Values      *TopK
+}
+
+func NewLiteralsReport(config LiteralsConfig, frames Frames) (LiteralsReport, error) {
+	report := LiteralsReport{config: config, key: make([]byte, 32), placeholders: make(map[string]*Placeholder)}
+	if _, err := rand.Read(report.key); err != nil {
+		return report, err
+	}
+
+	for _, frame := range frames {
+		query := frame.MySQLQuery
+		if query.Fingerprint == "" || query.Fingerprint == "E_NO_FINGERPRINT" {
+			continue
+		}
+
+		for _, literal := range ExtractLiterals(query.Query) {
+			key := query.Fingerprint + "\t" + strconv.Itoa(literal.Position)
+			placeholder, ok := report.placeholders[key]
+			if !ok {
+				// more counters than reported keeps the reported counts accurate
+				placeholder = &Placeholder{Fingerprint: query.Fingerprint, Position: literal.Position, Values: NewTopK(10 * config.TopK)}
+				report.placeholders[key] = placeholder
+			}
+			if placeholder.Column == "" {
+				placeholder.Column = literal.Column
+			}
+			placeholder.Values.Add(literal.Value)
+		}
+	}
+
+	return report, nil
+}
+
+// Placeholders returns the placeholders ordered by the share of their most
+// frequent value, most skewed first
+func (r *LiteralsReport) Placeholders() []*Placeholder {
+	result := make([]*Placeholder, 0, len(r.placeholders))
+	for _, placeholder := range r.placeholders {
+		result = append(result, placeholder)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].TopShare(), result[j].TopShare()
+		if a != b {
+			return a > b
+		}
+		if result[i].Values.Total != result[j].Values.Total {
+			return result[i].Values.Total > result[j].Values.Total
+		}
+		if result[i].Fingerprint != result[j].Fingerprint {
+			return result[i].Fingerprint < result[j].Fingerprint
+		}
+		return result[i].Position < result[j].Position
+	})
+	return result
+}
+
+// TopShare returns the share of the values that are the most frequent value
+func (p *Placeholder) TopShare() float64 {
+	top := p.Values.Top(1)
+	if len(top) == 0 {
+		return 0
+	}
+	return float64(top[0].Count) / float64(p.Values.Total)
+}
+
+// redact replaces a value with a keyed hash, so equal values stay
+// recognizable within the report without small values being guessable
+func (r *LiteralsReport) redact(value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return "h:" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+func (r *LiteralsReport) MarshalJSON() ([]byte, error) {
+	type value struct {
+		Value string  `json:"value"`
+		Count int     `json:"count"`
+		Error int     `json:"error"`
+		Share float64 `json:"share"`
+	}
+	type placeholder struct {
+		Fingerprint string  `json:"fingerprint"`
+		Position    int     `json:"position"`
+		Column      string  `json:"column,omitempty"`
+		Calls       int     `json:"calls"`
+		TopShare    float64 `json:"top_share"`
+		Skewed      bool    `json:"skewed"`
+		Values      []value `json:"values"`
+	}
+
+	result := []placeholder{}
+	for _, p := range r.Placeholders() {
+		entry := placeholder{
+			Fingerprint: p.Fingerprint,
+			Position:    p.Position,
+			Column:      p.Column,
+			Calls:       p.Values.Total,
+			TopShare:    p.TopShare(),
+			Skewed:      p.TopShare() >= r.config.Skew && p.Values.Total > 1,
+		}
+		for _, counter := range p.Values.Top(r.config.TopK) {
+			v := counter.Value
+			if r.config.Redact {
+				v = r.redact(v)
+			}
+			entry.Values = append(entry.Values, value{v, counter.Count, counter.Error, float64(counter.Count) / float64(p.Values.Total)})
+		}
+		result = append(result, entry)
+	}
+
+	return json.Marshal(result)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractLiterals(t *testing.T) {
+	assert.Equal(t, []Literal{
+		{1, "user_id", "42"},
+		{2, "state", "open"},
+		{3, "id", "1"},
+		{3, "id", "2"},
+		{3, "id", "3"},
+		{4, "score", "-5"},
+	}, ExtractLiterals("SELECT * FROM issues WHERE issues.user_id = 42 AND `state` = 'open' AND id IN (1, 2,3) AND score > -5"))
+
+	assert.Equal(t, []Literal{
+		{1, "a", "1"},
+		{2, "b", "it's"},
+		{1, "a", "2"},
+		{2, "b", "null"},
+	}, ExtractLiterals(`INSERT INTO t (a, b) VALUES (1, 'it\'s'), (2, NULL)`))
+
+	assert.Empty(t, ExtractLiterals("SELECT t1.a FROM t1"))
+}
+
+func TestTopK(t *testing.T) {
+	topK := NewTopK(2)
+	for _, value := range []string{"a", "a", "a", "b", "c"} {
+		topK.Add(value)
+	}
+	assert.Equal(t, 5, topK.Total)
+	top := topK.Top(2)
+	assert.Equal(t, TopKCounter{Value: "a", Count: 3}, top[0])
+	assert.Equal(t, TopKCounter{Value: "c", Count: 2, Error: 1}, top[1])
+
+	// the counts of a stream of distinct values stay bounded by the capacity
+	topK = NewTopK(3)
+	for i := 0; i < 100; i++ {
+		topK.Add(strconv.Itoa(i % 10))
+		topK.Add("hot")
+	}
+	top = topK.Top(3)
+	assert.Len(t, top, 3)
+	assert.Equal(t, TopKCounter{Value: "hot", Count: 100}, top[0])
+
+	// there is always room for one value
+	topK = NewTopK(0)
+	topK.Add("a")
+	topK.Add("b")
+	assert.Equal(t, []TopKCounter{{Value: "b", Count: 2, Error: 1}}, topK.Top(1))
+}
+
+func TestLiteralsReport(t *testing.T) {
+	var queries []timedQuery
+	for i, id := range []string{"7", "7", "7", "1", "2"} {
+		queries = append(queries, timedQuery{"SELECT * FROM users WHERE id = " + id + " /*controller:users*/", time.Duration(i) * time.Millisecond})
+	}
+	frames, _ := buildTransactions(t, 1, [][]timedQuery{queries})
+
+	report, err := NewLiteralsReport(LiteralsConfig{TopK: 2, Skew: 0.5}, frames)
+	assert.NoError(t, err)
+	placeholders := report.Placeholders()
+	assert.Len(t, placeholders, 1)
+	assert.Equal(t, "select * from users where id = ?", placeholders[0].Fingerprint)
+	assert.Equal(t, "id", placeholders[0].Column)
+	assert.Equal(t, 0.6, placeholders[0].TopShare())
+
+	report.config.Redact = true
+	b, err := report.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"skewed":true`)
+	assert.Contains(t, string(b), report.redact("7"))
+	assert.NotContains(t, string(b), `"value":"7"`)
+}
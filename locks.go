@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LockReport estimates how long transactions held the row locks of the
+// tables they wrote: from the first write statement on a table until the
+// transaction completed. Writes outside transactions only lock for the
+// statement itself and are left out.
+type LockReport struct {
+	config   StatisticsConfig
+	interval time.Duration
+	end      time.Duration
+	tables   map[string]*TableLocks
+	// transactions is keyed by normalized transaction id and table
+	transactions map[string]*TransactionLocks
+}
+
+// TableLocks are the lock holds of every transaction writing one table
+type TableLocks struct {
+	Name  string
+	Holds *Histogram
+	// Intervals are when the locks were held
+	Intervals []Interval
+	Tags      map[string]int
+}
+
+// TransactionLocks are the lock holds of one normalized transaction on one table
+type TransactionLocks struct {
+	ID          string
+	Fingerprint []string
+	Table       string
+	Holds       *Histogram
+}
+
+// NewLockReport estimates the lock holds of the transactions, reporting how
+// many transactions held the locks of each table per interval
+func NewLockReport(config StatisticsConfig, interval time.Duration, frames Frames, transactions Transactions) LockReport {
+	report := LockReport{
+		config:       config,
+		interval:     interval,
+		end:          NewTimeline(frames, transactions, io.Discard).End,
+		tables:       make(map[string]*TableLocks),
+		transactions: make(map[string]*TransactionLocks),
+	}
+
+	statements := make(StatementCache)
+	for _, t := range sortedTransactions(transactions) {
+		fingerprint := t.FingerprintWith(config.FingerprintStrategy)
+		id := TransactionID(fingerprint)
+
+		for _, hold := range lockHolds(t, statements) {
+			duration := hold.Interval.End - hold.Interval.Start
+
+			table := report.table(hold.Table)
+			table.Holds.Add(duration)
+			table.Intervals = append(table.Intervals, hold.Interval)
+			for k, v := range hold.First.MySQLQuery.Tags {
+				table.Tags[k+":"+v]++
+			}
+
+			key := id + "\t" + hold.Table
+			if _, ok := report.transactions[key]; !ok {
+				report.transactions[key] = &TransactionLocks{
+					ID:          id,
+					Fingerprint: fingerprint,
+					Table:       hold.Table,
+					Holds:       NewHistogram(config.RelativeError),
+				}
+			}
+			report.transactions[key].Holds.Add(duration)
+		}
+	}
+
+	return report
+}
+
+// lockHold is when a transaction held the locks of one table
+type lockHold struct {
+	Table       string
+	Transaction *Transaction
+	// First is the first write statement on the table
+	First    *Frame
+	Interval Interval
+}
+
+// lockHolds returns the lock holds of the transaction, one per table written
+func lockHolds(t *Transaction, statements StatementCache) []lockHold {
+	var result []lockHold
+	end := t.Frames[0].TimeRelative + t.TotalDuration()
+	held := make(map[string]bool)
+	for _, frame := range t.Frames {
+		statement := statements.Parse(frame.MySQLQuery.Fingerprint)
+		for _, name := range statement.Writes {
+			if held[name] {
+				continue
+			}
+			held[name] = true
+			result = append(result, lockHold{Table: name, Transaction: t, First: frame, Interval: Interval{Start: frame.TimeRelative, End: end}})
+		}
+	}
+	return result
+}
+
+func (r *LockReport) table(name string) *TableLocks {
+	if _, ok := r.tables[name]; !ok {
+		r.tables[name] = &TableLocks{
+			Name:  name,
+			Holds: NewHistogram(r.config.RelativeError),
+			Tags:  make(map[string]int),
+		}
+	}
+	return r.tables[name]
+}
+
+// Tables returns the tables ordered by total lock hold time, most first
+func (r *LockReport) Tables() []*TableLocks {
+	result := make([]*TableLocks, 0, len(r.tables))
+	for _, table := range r.tables {
+		result = append(result, table)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Holds.Sum != result[j].Holds.Sum {
+			return result[i].Holds.Sum > result[j].Holds.Sum
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Transactions returns the lock holds by normalized transaction and table,
+// ordered by total lock hold time, most first
+func (r *LockReport) Transactions() []*TransactionLocks {
+	result := make([]*TransactionLocks, 0, len(r.transactions))
+	for _, t := range r.transactions {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Holds.Sum != result[j].Holds.Sum {
+			return result[i].Holds.Sum > result[j].Holds.Sum
+		}
+		return result[i].ID+result[i].Table < result[j].ID+result[j].Table
+	})
+	return result
+}
+
+func (r *LockReport) MarshalJSON() ([]byte, error) {
+	type bucket struct {
+		Start float64 `json:"start"`
+		Level
+	}
+	type table struct {
+		Name     string         `json:"table"`
+		Hold     TimeStatistics `json:"hold"`
+		Tags     map[string]int `json:"tags"`
+		OverTime []bucket       `json:"over_time"`
+	}
+	type transaction struct {
+		ID          string         `json:"id"`
+		Fingerprint string         `json:"fingerprint"`
+		Table       string         `json:"table"`
+		Hold        TimeStatistics `json:"hold"`
+	}
+
+	dbs := NewDurationBuckets(r.interval)
+	data := struct {
+		Interval     float64       `json:"interval"`
+		Tables       []table       `json:"tables"`
+		Transactions []transaction `json:"transactions"`
+	}{
+		Interval:     float64(r.interval) / float64(time.Millisecond),
+		Tables:       []table{},
+		Transactions: []transaction{},
+	}
+
+	for _, t := range r.Tables() {
+		ts, err := NewTimeStatistics(t.Holds, r.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := table{Name: t.Name, Hold: ts, Tags: t.Tags, OverTime: []bucket{}}
+		// only the buckets where a transaction held the locks
+		for idx, level := range dbs.Levels(t.Intervals, r.end, nil) {
+			if level.Max > 0 {
+				start := time.Duration(idx) * r.interval
+				entry.OverTime = append(entry.OverTime, bucket{Start: float64(start) / float64(time.Millisecond), Level: level})
+			}
+		}
+		data.Tables = append(data.Tables, entry)
+	}
+
+	for _, t := range r.Transactions() {
+		ts, err := NewTimeStatistics(t.Holds, r.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+		data.Transactions = append(data.Transactions, transaction{
+			ID:          t.ID,
+			Fingerprint: strings.Join(t.Fingerprint, "; "),
+			Table:       t.Table,
+			Hold:        ts,
+		})
+	}
+
+	return json.Marshal(data)
+}
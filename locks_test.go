@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockReport(t *testing.T) {
+	// the same transaction twice, the second starting 50ms after the first
+	var queries [][]timedQuery
+	for id := 0; id < 2; id++ {
+		start := time.Duration(id) * 50 * time.Millisecond
+		queries = append(queries, []timedQuery{
+			{"BEGIN", start},
+			{"SELECT * FROM repos WHERE id = 1", start + 10*time.Millisecond},
+			{"UPDATE users SET name = 'a' WHERE id = 1 /*controller:users*/", start + 20*time.Millisecond},
+			{"UPDATE users SET name = 'b' WHERE id = 2", start + 30*time.Millisecond},
+			{"INSERT INTO audit VALUES (1)", start + 60*time.Millisecond},
+			{"COMMIT", start + 90*time.Millisecond},
+		})
+	}
+	frames, transactions := buildTransactions(t, 1, queries)
+	for _, frame := range frames {
+		frame.MySQLQuery.Duration = 10 * time.Millisecond
+	}
+
+	report := NewLockReport(DefaultStatisticsConfig(), 100*time.Millisecond, frames, transactions)
+
+	tables := report.Tables()
+	assert.Len(t, tables, 2)
+	assert.Equal(t, "users", tables[0].Name)
+	assert.Equal(t, 2, tables[0].Holds.Count)
+	assert.Equal(t, 160*time.Millisecond, tables[0].Holds.Sum)
+	assert.Equal(t, 2, tables[0].Tags["controller:users"])
+	assert.Equal(t, []Interval{{20 * time.Millisecond, 100 * time.Millisecond}, {70 * time.Millisecond, 150 * time.Millisecond}}, tables[0].Intervals)
+	assert.Equal(t, "audit", tables[1].Name)
+	assert.Equal(t, 80*time.Millisecond, tables[1].Holds.Sum)
+
+	locks := report.Transactions()
+	assert.Len(t, locks, 2)
+	assert.Equal(t, "users", locks[0].Table)
+	assert.Equal(t, TransactionID(transactions.Transactions[0].FingerprintSlice(true)), locks[0].ID)
+
+	dbs := NewDurationBuckets(report.interval)
+	levels := dbs.Levels(tables[0].Intervals, report.end, nil)
+	assert.Equal(t, 2, levels[0].Max)
+}
@@ -1,82 +1,345 @@
 // this expects a file in the format of the output of the following command piped into stdin:
-// tshark -r mysql.pcap -Tjson -e tcp.flags.fin -e tcp.flags.reset -e tcp.analysis.lost_segment -e tcp.analysis.ack_lost_segment -e frame.number -e frame.time_relative -e tcp.stream -e mysql.command -e mysql.query -e mysql.payload -e mysql.response_code
+// tshark -r mysql.pcap -Y 'mysql || tcp.flags.syn==1 || tcp.flags.fin==1 || tcp.flags.reset==1' -Tjson -e tcp.flags.syn -e tcp.flags.fin -e tcp.flags.reset -e tcp.analysis.lost_segment -e tcp.analysis.ack_lost_segment -e frame.number -e frame.time_relative -e frame.time_epoch -e ip.src -e ip.dst -e tcp.srcport -e tcp.dstport -e tcp.stream -e mysql.command -e mysql.query -e mysql.payload -e mysql.response_code -e mysql.user -e mysql.error_code -e mysql.error.message
+// or a set of rotated captures given with --input, see TsharkFields
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"sort"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 )
 
-type rawsource struct {
-	Layers Layers `json:"layers"`
-}
+func main() {
+	mode := flag.String("mode", "debug", "mode (debug, count-tags, queries-for-tag, tags-for-fingerprint, group-by, transactions, normalized-transactions, clusters, tables, cross-keyspace, locks, conflicts, literals, concurrency, connections, storms, idle-in-transaction, waterfall, trace, otlp, openmetrics, report, check, n-plus-one, merge, diff)")
 
-type rawframe struct {
-	Source rawsource `json:"_source"`
-}
+	// for queries-for-tag
+	key := flag.String("key", "", "key")
+	value := flag.String("value", "", "value")
 
-func main() {
-	var rawframes []rawframe
-	r := bufio.NewReader(os.Stdin)
-	dec := json.NewDecoder(r)
+	// for query-for-fingerprint
+	fingerprint := flag.String("fingerprint", "", "fingerprint")
+
+	// for group-by
+	groupBy := flag.String("group-by", "fingerprint", "comma separated dimensions to group by (fingerprint, stream, command, error, tag, tag:<key>)")
+	metrics := flag.String("metrics", "", "comma separated metrics to report (count, sum, min, mean, max or a percentile like p99.9), durations in milliseconds (default count, sum, the --percentiles and max)")
+
+	// for concurrency
+	intervals := flag.String("intervals", "100ms", "comma separated bucket sizes of the concurrency time series, e.g. 10ms,100ms,1s")
+
+	// for connections
+	serverPort := flag.Int("server-port", 3306, "port of the mysql server, tells clients and the server apart on connections without queries")
+
+	// for storms
+	stormInterval := flag.Duration("storm-interval", DefaultStormConfig().Interval, "bucket size new connections and resets are counted in")
+	stormFactor := flag.Float64("storm-factor", DefaultStormConfig().Factor, "how many times the median per bucket new connections or resets must exceed to be a storm")
+	stormMinimum := flag.Int("storm-minimum", DefaultStormConfig().Minimum, "fewest new connections or resets in a bucket that can be a storm")
+
+	// for idle-in-transaction
+	top := flag.Int("top", 20, "number of biggest idle-in-transaction gaps, conflicts and hot tables and keys to list")
+
+	// for waterfall, which also takes --fingerprint
+	frameNumber := flag.Int("frame", -1, "frame number of a statement of the transaction to render, with --stream if several capture files have it")
+	stream := flag.Int("stream", -1, "render the slowest transaction on this stream")
+	width := flag.Int("width", 60, "width of the waterfall bars in characters")
+	svgPath := flag.String("svg", "", "also write the waterfall as SVG to this file")
+	id := flag.String("id", "", "render the slowest transaction with this normalized transaction id")
+
+	// for statistics
+	relativeError := flag.Float64("relative-error", DefaultRelativeError, "relative error of latency percentiles")
+	percentiles := flag.String("percentiles", "50,95,99", "comma separated percentiles to report")
+	strategy := flag.String("transaction-fingerprint", TransactionFingerprintSet, "how transactions are normalized (exact, set, collapsed)")
+
+	// for input
+	input := flag.String("input", "", "directory or glob of rotated capture files (tshark .json or pcap) to analyze as one timeline instead of stdin")
+	follow := flag.Bool("follow", false, "with --input, keep picking up new capture files until interrupted")
+	pollInterval := flag.Duration("poll-interval", time.Second, "how often to look for new capture files with --follow")
+
+	// for checkpoints
+	checkpointPath := flag.String("checkpoint", "", "periodically save the state of the analysis to this file")
+	checkpointInterval := flag.Duration("checkpoint-interval", 5*time.Minute, "how often to save a checkpoint")
+	resume := flag.Bool("resume", false, "continue from the last checkpoint and input position in --checkpoint")
+
+	// for summaries
+	summaryPath := flag.String("summary", "", "also write a mergeable summary of the analysis to this file")
+	source := flag.String("source", "", "name of the capture in the summary (default hostname)")
+
+	// for clusters
+	similarity := flag.Float64("similarity", DefaultSimilarity, "how alike transaction fingerprints must be to cluster, 1 minus their edit distance relative to the longer one")
+
+	// for cross-keyspace
+	keyspacesPath := flag.String("keyspaces", "", "JSON file mapping tables to keyspaces")
+
+	// for literals
+	topK := flag.Int("top-k", DefaultLiteralsConfig().TopK, "number of most frequent values to report per placeholder")
+	skew := flag.Float64("skew", DefaultLiteralsConfig().Skew, "share of calls one value must make up for a placeholder to be skewed")
+	redactLiterals := flag.Bool("redact", false, "replace literal values with a hash of them, so the report is safe to share")
+
+	// for n-plus-one
+	scope := flag.String("scope", DefaultNPlusOneConfig().Scope, "unit to look for N+1 patterns in (transaction, request, stream)")
+	minRepeat := flag.Int("min-repeat", DefaultNPlusOneConfig().MinRepeat, "fewest repetitions of a SELECT that are an N+1 pattern")
+	maxGap := flag.Duration("max-gap", DefaultNPlusOneConfig().MaxGap, "longest time between the repetitions of an N+1 pattern")
+
+	// for check
+	budgetPath := flag.String("budget", "", "budget file to check the analysis against")
+
+	// for diff
+	alpha := flag.Float64("alpha", DefaultDiffConfig().Alpha, "significance level of the statistical tests of diff")
+	minChange := flag.Float64("min-change", DefaultDiffConfig().MinChange, "smallest relative change of p50 or p95 latency diff reports")
+
+	flag.Parse()
+
+	config := StatisticsConfig{RelativeError: *relativeError}
+	if config.RelativeError <= 0 || config.RelativeError >= 1 {
+		log.Fatal("relative-error must be between 0 and 1")
+	}
+	var err error
+	if config.Percentiles, err = ParsePercentiles(*percentiles); err != nil {
+		log.Fatal(err)
+	}
+	if config.FingerprintStrategy, err = ParseFingerprintStrategy(*strategy); err != nil {
+		log.Fatal(err)
+	}
+
+	concurrencyIntervals, err := ParseIntervals(*intervals)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// before reading the capture
+	if *mode == "queries-for-tag" && *key == "" {
+		log.Fatal("queries-for-tag needs a --key")
+	}
+
+	if *mode == "diff" {
+		// compare two summary files given as arguments
+		if flag.NArg() != 2 {
+			log.Fatal("diff takes two summary files, before and after")
+		}
+
+		var summaries []Summary
+		for _, path := range flag.Args() {
+			summary, err := ReadSummary(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			summaries = append(summaries, summary)
+		}
 
-	for {
-		if err := dec.Decode(&rawframes); err == io.EOF {
-			break
-		} else if err != nil {
+		diff, err := NewDiff(config, DiffConfig{Alpha: *alpha, MinChange: *minChange}, &summaries[0], &summaries[1])
+		if err != nil {
 			log.Fatal(err)
 		}
+
+		b, err := json.MarshalIndent(&diff, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	if *mode == "merge" {
+		// merge summary files given as arguments instead of reading a capture
+		var merged Summary
+		for _, path := range flag.Args() {
+			summary, err := ReadSummary(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := merged.Merge(&summary, config); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if *summaryPath != "" {
+			if err := merged.Write(*summaryPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		b, err := merged.Report(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
 	}
 
 	fp := NewFrameParser()
 
-	if err := fp.ParseRawFrames(rawframes); err != nil {
-		log.Fatal(err)
+	var checkpointer *Checkpointer
+	var resumed Checkpoint
+	if *checkpointPath != "" {
+		checkpointer = NewCheckpointer(*checkpointPath, *checkpointInterval)
+
+		if *resume {
+			var ok bool
+			if resumed, ok, err = checkpointer.Resume(&fp); err != nil {
+				log.Fatal(err)
+			} else if ok {
+				fmt.Fprintf(os.Stderr, "resuming after %d files and %d frames\n", len(resumed.Files), len(fp.Frames))
+			}
+		}
+	} else if *resume {
+		log.Fatal("--resume requires --checkpoint")
 	}
 
-	mode := flag.String("mode", "debug", "mode (debug, count-tags, queries-for-tag, tags-for-fingerprint)")
+	// process adds the frames of a capture file, "-" for stdin
+	process := func(path string) error {
+		key, err := CaptureKey(path)
+		if err != nil {
+			return err
+		}
+		if checkpointer != nil && checkpointer.Done(key) {
+			return nil
+		}
 
-	// for queries-for-tag
-	key := flag.String("key", "", "key")
-	value := flag.String("value", "", "value")
+		var rawframes []rawframe
+		if path == "-" {
+			rawframes, err = readRawFrames(os.Stdin)
+		} else {
+			rawframes, err = readCapture(path)
+			fmt.Fprintf(os.Stderr, "%s: %d frames\n", path, len(rawframes))
+		}
+		if err != nil {
+			return err
+		}
 
-	// for query-for-fingerprint
-	fingerprint := flag.String("fingerprint", "", "fingerprint")
+		offset := 0
+		if key == resumed.File {
+			// continue the file the checkpoint was taken in
+			offset = resumed.Offset
+			resumed = Checkpoint{}
+		} else {
+			fp.StartFile()
+		}
 
-	flag.Parse()
+		for i := offset; i < len(rawframes); i++ {
+			if err := fp.AddRawFrame(rawframes[i]); err != nil {
+				return err
+			}
+
+			if checkpointer != nil && checkpointer.Due() {
+				if err := checkpointer.Save(&fp, key, i+1); err != nil {
+					return err
+				}
+			}
+		}
+
+		if checkpointer != nil {
+			checkpointer.FileDone(key)
+		}
+		return nil
+	}
+
+	switch {
+	case *input != "" && *follow:
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			signal.Stop(signals)
+			close(stop)
+		}()
+
+		if err := FollowCaptures(*input, *pollInterval, stop, process); err != nil {
+			log.Fatal(err)
+		}
+
+	case *input != "":
+		files, err := CaptureFiles(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(files) == 0 {
+			log.Fatalf("no capture files match %s", *input)
+		}
+
+		for _, file := range files {
+			if err := process(file); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+	default:
+		if err := process("-"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if checkpointer != nil {
+		// a final checkpoint lets --resume report without re-reading any input
+		if err := checkpointer.Save(&fp, "", 0); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fp.Finish()
+
+	if *summaryPath != "" {
+		groupBys := append([]string{}, SummaryGroupBys...)
+		if *mode == "group-by" && *groupBy != "fingerprint" && *groupBy != "tag" {
+			groupBys = append(groupBys, *groupBy)
+		}
+
+		if *source == "" {
+			if *source, err = os.Hostname(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		summary, err := NewSummary(*source, config, &fp, groupBys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := summary.Write(*summaryPath); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	switch *mode {
 	case "debug":
 		spew.Dump(fp.Transactions)
 		spew.Dump(fp.Frames)
 
-	case "count-tags":
-		tags := fp.Frames.CountByTag()
-		for k, v := range tags {
-			fmt.Println(k, v)
+	case "count-tags", "queries-for-tag", "tags-for-fingerprint", "group-by":
+		var gb GroupBy
+		ms := []Metric{MetricCount}
+
+		switch *mode {
+		case "count-tags":
+			gb = NewGroupBy(config, mustParseDimensions("tag"))
+		case "queries-for-tag":
+			gb = NewGroupBy(config, mustParseDimensions("fingerprint"), Filter{Dimension: mustParseDimensions("tag:" + *key)[0], Value: *value})
+		case "tags-for-fingerprint":
+			gb = NewGroupBy(config, mustParseDimensions("tag"), Filter{Dimension: mustParseDimensions("fingerprint")[0], Value: *fingerprint})
+		case "group-by":
+			gb = NewGroupBy(config, mustParseDimensions(*groupBy))
+
+			ms = DefaultMetrics(config.Percentiles)
+			if *metrics != "" {
+				if ms, err = ParseMetrics(*metrics); err != nil {
+					log.Fatal(err)
+				}
+			}
 		}
 
-	case "queries-for-tag":
-		queries := fp.Frames.QueriesForTag(*key, *value)
-		for q, count := range queries {
-			fmt.Println(count, "\t", q)
+		for _, frame := range fp.Frames {
+			gb.AddFrame(frame)
 		}
 
-	case "tags-for-fingerprint":
-		fmt.Println("Fingerprint: ", *fingerprint)
-		tags := fp.Frames.TagsForFingerprint(*fingerprint)
-		for tag, count := range tags {
-			fmt.Println(count, "\t", tag)
+		tsv, err := gb.TSV(ms)
+		if err != nil {
+			log.Fatal(err)
 		}
+		fmt.Print(tsv)
 
 	case "transactions":
 		for _, t := range fp.Transactions.Transactions {
@@ -100,7 +363,7 @@ func main() {
 		}
 
 	case "normalized-transactions":
-		nts := NewNormalizedTransactions()
+		nts := NewNormalizedTransactions(config)
 		for _, t := range fp.Transactions.Transactions {
 			nts.Add(*t)
 		}
@@ -111,21 +374,213 @@ func main() {
 		}
 		fmt.Println(string(b))
 	case "concurrency":
-		frames := make(Frames, len(fp.Frames))
+		timeline := NewTimeline(fp.Frames, fp.Transactions, os.Stderr)
+
+		var series []ConcurrencySeries
+		for _, interval := range concurrencyIntervals {
+			dbs := NewDurationBuckets(interval)
+			series = append(series, dbs.Series(timeline, config.Percentiles))
+		}
+		fmt.Print(ConcurrencyTSV(series, config.Percentiles))
+
+	case "connections":
+		report := NewConnectionReport(config, fp.Frames, fp.Transactions, *serverPort)
+
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "idle-in-transaction":
+		report := NewIdleReport(config, *top)
+		for _, t := range fp.Transactions.Transactions {
+			report.Add(t)
+		}
+
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "clusters":
+		nts := NewNormalizedTransactions(config)
+		for _, t := range fp.Transactions.Transactions {
+			nts.Add(*t)
+		}
+
+		clusters, err := NewClusters(config, *similarity, &nts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := json.MarshalIndent(&clusters, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
 
-		copy(frames, fp.Frames)
+	case "tables":
+		report := NewTablesReport(config, fp.Frames, fp.Transactions)
 
-		sort.SliceStable(frames, func(i, j int) bool {
-			return frames[i].TimeRelative < frames[j].TimeRelative
-		})
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "cross-keyspace":
+		if *keyspacesPath == "" {
+			log.Fatal("cross-keyspace needs a --keyspaces file")
+		}
+		keyspaces, err := ReadKeyspaceMap(*keyspacesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		report := NewCrossKeyspaceReport(config, keyspaces, fp.Transactions)
+
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "locks":
+		report := NewLockReport(config, concurrencyIntervals[0], fp.Frames, fp.Transactions)
 
-		dbs := NewDurationBuckets(100 * time.Millisecond)
-		for _, frame := range frames {
-			if err := dbs.AddFrame(*frame); err != nil {
-				fmt.Fprintf(os.Stderr, "%v: %+v", err, frame)
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "conflicts":
+		report := NewConflictReport(config, *top, fp.Frames, fp.Transactions)
+
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "literals":
+		if *topK <= 0 {
+			log.Fatal("--top-k must be positive")
+		}
+		report, err := NewLiteralsReport(LiteralsConfig{TopK: *topK, Skew: *skew, Redact: *redactLiterals}, fp.Frames)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "waterfall":
+		t, err := SelectTransaction(fp.Transactions, *frameNumber, *stream, *fingerprint, *id, config.FingerprintStrategy)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		waterfall := NewWaterfall(t)
+		fmt.Print(waterfall.ASCII(*width))
+
+		if *svgPath != "" {
+			if err := os.WriteFile(*svgPath, []byte(waterfall.SVG()), 0644); err != nil {
+				log.Fatal(err)
 			}
 		}
-		fmt.Print(dbs.TSV())
+
+	case "trace":
+		trace := NewTrace(fp.Frames, fp.Transactions)
+
+		b, err := json.Marshal(&trace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "otlp":
+		export := NewOTLPExport(fp.Frames, fp.Transactions)
+
+		b, err := json.Marshal(&export)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "openmetrics":
+		om := NewOpenMetrics(config, os.Stdout)
+		if err := om.Write(fp.Frames, fp.Transactions); err != nil {
+			log.Fatal(err)
+		}
+
+	case "report":
+		report, err := NewHTMLReport(config, &fp, concurrencyIntervals[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := report.Write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+
+	case "n-plus-one":
+		nc := NPlusOneConfig{Scope: *scope, MinRepeat: *minRepeat, MaxGap: *maxGap}
+		report, err := NewNPlusOneReport(config, nc, fp.Frames, fp.Transactions)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+
+	case "check":
+		if *budgetPath == "" {
+			log.Fatal("check needs a --budget file")
+		}
+		budget, err := ReadBudget(*budgetPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		violations, checked := budget.Check(config, fp.Frames, fp.Transactions)
+		for _, violation := range violations {
+			fmt.Println("FAIL", violation)
+		}
+		if len(violations) > 0 {
+			fmt.Printf("%d violations of %d rules\n", len(violations), checked)
+			os.Exit(1)
+		}
+		fmt.Printf("OK, %d rules checked\n", checked)
+
+	case "storms":
+		if *stormInterval <= 0 {
+			log.Fatal("--storm-interval must be positive")
+		}
+
+		sc := StormConfig{Interval: *stormInterval, Factor: *stormFactor, Minimum: *stormMinimum}
+		report := NewStormReport(config, sc, fp.Frames, *serverPort)
+
+		b, err := json.MarshalIndent(&report, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
 	}
 
 }
+
+func mustParseDimensions(spec string) []Dimension {
+	dimensions, err := ParseDimensions(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dimensions
+}
@@ -13,6 +13,13 @@ type MySQLQuery struct {
 	Tags        map[string]string
 	Fingerprint string
 	Duration    time.Duration
+	// RequestID and TraceParent are taken out of the tags to correlate the
+	// query with application traces
+	RequestID   string
+	TraceParent string
+	// ErrorCode and Error are set when the server responded with an error
+	ErrorCode int
+	Error     string
 }
 
 func NewMySQLQuery(rawquery string) MySQLQuery {
@@ -34,6 +41,10 @@ func NewMySQLQuery(rawquery string) MySQLQuery {
 				// skip these tags, they are not useful or have high cardinality
 				switch kv[0] {
 				case "request_id":
+					result.RequestID = kv[1]
+					continue
+				case "traceparent":
+					result.TraceParent = kv[1]
 					continue
 				case "server":
 					continue
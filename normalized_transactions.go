@@ -3,11 +3,13 @@ package main
 import (
 	"encoding/json"
 	"sort"
-	"time"
+	"strings"
 )
 
 type NormalizedTransactions struct {
-	// Transactions is a map of normalized transactions, keyed by transaction fingerprint
+	config StatisticsConfig
+	// Transactions is a map of normalized transactions, keyed by transaction
+	// fingerprint of the configured strategy
 	Transactions map[string]*NormalizedTransaction `json:"transactions"`
 }
 
@@ -15,22 +17,28 @@ type NormalizedTransaction struct {
 	Fingerprint          []string
 	Example              []string
 	tags                 map[string]bool
-	queryDurations       []time.Duration
-	transactionDurations []time.Duration
-	wasteDurations       []time.Duration
+	percentiles          []float64
+	queryDurations       *Histogram
+	transactionDurations *Histogram
+	wasteDurations       *Histogram
+	// sources breaks the transactions down by the capture they were seen in,
+	// set when the transactions are merged from summaries
+	sources map[string]*SourceTotals
 }
 
-func NewNormalizedTransactions() NormalizedTransactions {
+func NewNormalizedTransactions(config StatisticsConfig) NormalizedTransactions {
 	return NormalizedTransactions{
+		config:       config,
 		Transactions: make(map[string]*NormalizedTransaction),
 	}
 }
 
 func (nts *NormalizedTransactions) Add(transaction Transaction) {
-	nt := NewNormalizedTransaction()
-	fingerprint := transaction.Fingerprint()
+	nt := NewNormalizedTransaction(nts.config)
+	normalized := transaction.FingerprintWith(nts.config.FingerprintStrategy)
+	fingerprint := strings.Join(normalized, "\n") + "\n"
 	if _, ok := nts.Transactions[fingerprint]; !ok {
-		nt.Fingerprint = transaction.FingerprintSlice(true)
+		nt.Fingerprint = normalized
 		nt.Example = transaction.FingerprintSlice(false)
 		nts.Transactions[fingerprint] = &nt
 	}
@@ -41,9 +49,26 @@ func (nts *NormalizedTransactions) Add(transaction Transaction) {
 		}
 	}
 
-	nts.Transactions[fingerprint].queryDurations = append(nts.Transactions[fingerprint].queryDurations, transaction.QueryDuration())
-	nts.Transactions[fingerprint].transactionDurations = append(nts.Transactions[fingerprint].transactionDurations, transaction.TotalDuration())
-	nts.Transactions[fingerprint].wasteDurations = append(nts.Transactions[fingerprint].wasteDurations, transaction.WasteDuration())
+	nts.Transactions[fingerprint].queryDurations.Add(transaction.QueryDuration())
+	nts.Transactions[fingerprint].transactionDurations.Add(transaction.TotalDuration())
+	nts.Transactions[fingerprint].wasteDurations.Add(transaction.WasteDuration())
+}
+
+// Merge adds the normalized transactions of another analysis, matched by fingerprint
+func (nts *NormalizedTransactions) Merge(other *NormalizedTransactions) error {
+	for fingerprint, transaction := range other.Transactions {
+		if _, ok := nts.Transactions[fingerprint]; !ok {
+			nt := NewNormalizedTransaction(nts.config)
+			nt.Fingerprint = transaction.Fingerprint
+			nt.Example = transaction.Example
+			nts.Transactions[fingerprint] = &nt
+		}
+
+		if err := nts.Transactions[fingerprint].Merge(transaction); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (nts *NormalizedTransactions) MarshalJSON() ([]byte, error) {
@@ -54,10 +79,38 @@ func (nts *NormalizedTransactions) MarshalJSON() ([]byte, error) {
 	return json.Marshal(data)
 }
 
-func NewNormalizedTransaction() NormalizedTransaction {
+func NewNormalizedTransaction(config StatisticsConfig) NormalizedTransaction {
 	return NormalizedTransaction{
-		tags: make(map[string]bool),
+		tags:                 make(map[string]bool),
+		percentiles:          config.Percentiles,
+		queryDurations:       NewHistogram(config.RelativeError),
+		transactionDurations: NewHistogram(config.RelativeError),
+		wasteDurations:       NewHistogram(config.RelativeError),
+	}
+}
+
+// ID returns the stable id of the transaction shape
+func (nt *NormalizedTransaction) ID() string {
+	return TransactionID(nt.Fingerprint)
+}
+
+func (nt *NormalizedTransaction) Merge(other *NormalizedTransaction) error {
+	for tag := range other.tags {
+		nt.tags[tag] = true
+	}
+
+	if err := nt.queryDurations.Merge(other.queryDurations); err != nil {
+		return err
+	}
+	if err := nt.transactionDurations.Merge(other.transactionDurations); err != nil {
+		return err
 	}
+	if err := nt.wasteDurations.Merge(other.wasteDurations); err != nil {
+		return err
+	}
+
+	nt.sources = mergeSourceTotals(nt.sources, other.sources)
+	return nil
 }
 
 func (nt *NormalizedTransaction) MarshalJSON() ([]byte, error) {
@@ -66,6 +119,7 @@ func (nt *NormalizedTransaction) MarshalJSON() ([]byte, error) {
 	var wasteStatistics TimeStatistics
 
 	data := struct {
+		ID                    string          `json:"id"`
 		Fingerprint           []string        `json:"fingerprint"`
 		Example               []string        `json:"example_query"`
 		WastePercentage       float64         `json:"waste_percentage"`
@@ -73,24 +127,27 @@ func (nt *NormalizedTransaction) MarshalJSON() ([]byte, error) {
 		QueryStatistics       *TimeStatistics `json:"query_statistics"`
 		TransactionStatistics *TimeStatistics `json:"transaction_statistics"`
 		WasteStatistics       *TimeStatistics `json:"waste_statistics"`
+		Sources               SourceReport    `json:"sources,omitempty"`
 	}{
+		ID:          nt.ID(),
 		Fingerprint: nt.Fingerprint,
 		Example:     nt.Example,
+		Sources:     NewSourceReport(nt.sources),
 	}
 
-	queryStatistics, err := NewTimeStatistics(nt.queryDurations)
+	queryStatistics, err := NewTimeStatistics(nt.queryDurations, nt.percentiles)
 	if err != nil {
 		return nil, err
 	}
 	data.QueryStatistics = &queryStatistics
 
-	transactionStatistics, err = NewTimeStatistics(nt.transactionDurations)
+	transactionStatistics, err = NewTimeStatistics(nt.transactionDurations, nt.percentiles)
 	if err != nil {
 		return nil, err
 	}
 	data.TransactionStatistics = &transactionStatistics
 
-	wasteStatistics, err = NewTimeStatistics(nt.wasteDurations)
+	wasteStatistics, err = NewTimeStatistics(nt.wasteDurations, nt.percentiles)
 	if err != nil {
 		return nil, err
 	}
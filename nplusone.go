@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the units N+1 patterns are looked for in
+const (
+	ScopeTransaction = "transaction"
+	ScopeRequest     = "request"
+	ScopeStream      = "stream"
+)
+
+// NPlusOneConfig configures what counts as an N+1 pattern
+type NPlusOneConfig struct {
+	// Scope is the unit the repetitions must happen in
+	Scope string
+	// MinRepeat is the fewest repetitions of a fingerprint that are a pattern
+	MinRepeat int
+	// MaxGap is the longest time between the response to one repetition and the next
+	MaxGap time.Duration
+}
+
+func DefaultNPlusOneConfig() NPlusOneConfig {
+	return NPlusOneConfig{Scope: ScopeStream, MinRepeat: 5, MaxGap: 10 * time.Millisecond}
+}
+
+// NPlusOneReport holds the N+1 patterns of a capture by fingerprint
+type NPlusOneReport struct {
+	config   StatisticsConfig
+	patterns map[string]*NPlusOnePattern
+}
+
+// NPlusOnePattern is a SELECT fingerprint that was repeated in quick
+// succession with different literal values, in one or more bursts
+type NPlusOnePattern struct {
+	Fingerprint string
+	// Bursts is the number of times the pattern was seen
+	Bursts int
+	// Repetitions is the number of queries in all bursts, MaxRepetitions in the biggest
+	Repetitions    int
+	MaxRepetitions int
+	// Durations are of every query of every burst
+	Durations *Histogram
+	Tags      map[string]int
+	// Example is the biggest burst
+	Example []*Frame
+	// ExampleUnit names the transaction, request or stream of the example
+	ExampleUnit string
+}
+
+// Suggestion returns the batched shape of the query, fetching every row in one go
+func (p *NPlusOnePattern) Suggestion() string {
+	if idx := strings.LastIndex(p.Fingerprint, " = ?"); idx >= 0 {
+		return p.Fingerprint[:idx] + " in (?+)" + p.Fingerprint[idx+len(" = ?"):]
+	}
+	return "batch the lookups into one query"
+}
+
+// NewNPlusOneReport looks for N+1 patterns in every unit of the scope
+func NewNPlusOneReport(config StatisticsConfig, nc NPlusOneConfig, frames Frames, transactions Transactions) (NPlusOneReport, error) {
+	report := NPlusOneReport{config: config, patterns: make(map[string]*NPlusOnePattern)}
+
+	units := make(map[string][]*Frame)
+	switch nc.Scope {
+	case ScopeTransaction:
+		for _, t := range transactions.Transactions {
+			units["transaction at frame "+strconv.Itoa(t.Frames[0].Number)] = t.Frames
+		}
+	case ScopeRequest:
+		for _, frame := range frames {
+			if id := frame.MySQLQuery.RequestID; id != "" {
+				units["request "+id] = append(units["request "+id], frame)
+			}
+		}
+	case ScopeStream:
+		for _, frame := range frames {
+			key := "stream " + strconv.Itoa(frame.TCPStream)
+			units[key] = append(units[key], frame)
+		}
+	default:
+		return report, fmt.Errorf("unknown scope %q", nc.Scope)
+	}
+
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		report.addUnit(nc, name, units[name])
+	}
+
+	return report, nil
+}
+
+// addUnit finds the bursts of every SELECT fingerprint in the frames of one unit.
+// Other queries may run between the repetitions, as when a loop does several
+// lookups per row.
+func (r *NPlusOneReport) addUnit(nc NPlusOneConfig, name string, frames []*Frame) {
+	sorted := make([]*Frame, 0, len(frames))
+	for _, frame := range frames {
+		if strings.HasPrefix(frame.MySQLQuery.Fingerprint, "select") {
+			sorted = append(sorted, frame)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TimeRelative < sorted[j].TimeRelative
+	})
+
+	byFingerprint := make(map[string][]*Frame)
+	var order []string
+	for _, frame := range sorted {
+		fingerprint := frame.MySQLQuery.Fingerprint
+		if _, ok := byFingerprint[fingerprint]; !ok {
+			order = append(order, fingerprint)
+		}
+		byFingerprint[fingerprint] = append(byFingerprint[fingerprint], frame)
+	}
+
+	for _, fingerprint := range order {
+		var burst []*Frame
+		for _, frame := range byFingerprint[fingerprint] {
+			if len(burst) > 0 {
+				previous := burst[len(burst)-1]
+				if frame.TimeRelative-previous.TimeRelative-previous.MySQLQuery.Duration > nc.MaxGap {
+					r.addBurst(nc, name, burst)
+					burst = nil
+				}
+			}
+			burst = append(burst, frame)
+		}
+		r.addBurst(nc, name, burst)
+	}
+}
+
+func (r *NPlusOneReport) addBurst(nc NPlusOneConfig, name string, burst []*Frame) {
+	if len(burst) < nc.MinRepeat {
+		return
+	}
+
+	// the same literals over and over are a retry or a cache miss, not a
+	// loop over rows, even if the queries are spelled differently
+	literals := make(map[string]bool)
+	for _, frame := range burst {
+		var values []string
+		for _, literal := range ExtractLiterals(frame.MySQLQuery.Query) {
+			values = append(values, literal.Value)
+		}
+		literals[strings.Join(values, "\x00")] = true
+	}
+	if len(literals) < 2 {
+		return
+	}
+
+	fingerprint := burst[0].MySQLQuery.Fingerprint
+	pattern, ok := r.patterns[fingerprint]
+	if !ok {
+		pattern = &NPlusOnePattern{
+			Fingerprint: fingerprint,
+			Durations:   NewHistogram(r.config.RelativeError),
+			Tags:        make(map[string]int),
+		}
+		r.patterns[fingerprint] = pattern
+	}
+
+	pattern.Bursts++
+	pattern.Repetitions += len(burst)
+	if len(burst) > pattern.MaxRepetitions {
+		pattern.MaxRepetitions = len(burst)
+		pattern.Example = burst
+		pattern.ExampleUnit = name
+	}
+
+	tags := make(map[string]bool)
+	for _, frame := range burst {
+		pattern.Durations.Add(frame.MySQLQuery.Duration)
+		for k, v := range frame.MySQLQuery.Tags {
+			tags[k+":"+v] = true
+		}
+	}
+	for tag := range tags {
+		pattern.Tags[tag]++
+	}
+}
+
+// Patterns returns the patterns ordered by total time, most first
+func (r *NPlusOneReport) Patterns() []*NPlusOnePattern {
+	result := make([]*NPlusOnePattern, 0, len(r.patterns))
+	for _, pattern := range r.patterns {
+		result = append(result, pattern)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Durations.Sum != result[j].Durations.Sum {
+			return result[i].Durations.Sum > result[j].Durations.Sum
+		}
+		return result[i].Fingerprint < result[j].Fingerprint
+	})
+
+	return result
+}
+
+func (r *NPlusOneReport) MarshalJSON() ([]byte, error) {
+	type pattern struct {
+		Fingerprint    string         `json:"fingerprint"`
+		Suggestion     string         `json:"suggestion"`
+		Bursts         int            `json:"bursts"`
+		Repetitions    int            `json:"repetitions"`
+		MaxRepetitions int            `json:"max_repetitions"`
+		Duration       TimeStatistics `json:"duration"`
+		Tags           map[string]int `json:"tags"`
+		ExampleUnit    string         `json:"example_unit"`
+		Example        []string       `json:"example_queries"`
+	}
+
+	result := []pattern{}
+	for _, p := range r.Patterns() {
+		ts, err := NewTimeStatistics(p.Durations, r.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := pattern{
+			Fingerprint:    p.Fingerprint,
+			Suggestion:     p.Suggestion(),
+			Bursts:         p.Bursts,
+			Repetitions:    p.Repetitions,
+			MaxRepetitions: p.MaxRepetitions,
+			Duration:       ts,
+			Tags:           p.Tags,
+			ExampleUnit:    p.ExampleUnit,
+		}
+		// the first few are enough to see the loop
+		for idx, frame := range p.Example {
+			if idx == 5 {
+				break
+			}
+			entry.Example = append(entry.Example, frame.MySQLQuery.Query)
+		}
+		result = append(result, entry)
+	}
+
+	return json.Marshal(struct {
+		Patterns []pattern `json:"patterns"`
+	}{result})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNPlusOneReport(t *testing.T) {
+	loop := []timedQuery{{"SELECT * FROM repos WHERE owner_id = 7 /*controller:repos*/", 0}}
+	for i := 0; i < 4; i++ {
+		at := time.Duration(1+2*i) * time.Millisecond
+		loop = append(loop,
+			timedQuery{"SELECT * FROM users WHERE id = " + string(rune('1'+i)) + " /*controller:repos*/", at},
+			timedQuery{"SELECT 1 FROM flags", at + time.Millisecond})
+	}
+	// too far from the loop above
+	loop = append(loop, timedQuery{"SELECT * FROM users WHERE id = 9", time.Second})
+	// the same literals are not a loop over rows, however the query is spelled
+	var same []timedQuery
+	for i, query := range []string{"SELECT * FROM users WHERE id = 1", "select * from users where id = 1", "SELECT *  FROM users\nWHERE id = 1", "SELECT * FROM users WHERE id = '1'"} {
+		same = append(same, timedQuery{query, time.Duration(i) * time.Millisecond})
+	}
+	frames, _ := buildTransactions(t, 1, [][]timedQuery{loop, same})
+
+	config := NPlusOneConfig{Scope: ScopeStream, MinRepeat: 4, MaxGap: 5 * time.Millisecond}
+	report, err := NewNPlusOneReport(DefaultStatisticsConfig(), config, frames, Transactions{})
+	assert.NoError(t, err)
+
+	patterns := report.Patterns()
+	assert.Len(t, patterns, 1)
+	assert.Equal(t, "select * from users where id = ?", patterns[0].Fingerprint)
+	assert.Equal(t, "select * from users where id in (?+)", patterns[0].Suggestion())
+	assert.Equal(t, 1, patterns[0].Bursts)
+	assert.Equal(t, 4, patterns[0].Repetitions)
+	assert.Equal(t, 4, patterns[0].Durations.Count)
+	assert.Equal(t, "stream 1", patterns[0].ExampleUnit)
+	assert.Equal(t, 1, patterns[0].Tags["controller:repos"])
+
+	_, err = NewNPlusOneReport(DefaultStatisticsConfig(), NPlusOneConfig{Scope: "nope"}, frames, Transactions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenMetricsBuckets are the upper bounds of the latency histogram buckets
+var OpenMetricsBuckets = []time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// OpenMetrics writes the analysis of a capture in the OpenMetrics text format
+type OpenMetrics struct {
+	config StatisticsConfig
+	w      io.Writer
+	err    error
+}
+
+func NewOpenMetrics(config StatisticsConfig, w io.Writer) OpenMetrics {
+	return OpenMetrics{config: config, w: w}
+}
+
+// label is one label of a sample
+type label struct {
+	name  string
+	value string
+}
+
+// Write writes the metrics of the capture followed by the # EOF terminator
+func (om *OpenMetrics) Write(frames Frames, transactions Transactions) error {
+	byFingerprint := NewGroupBy(om.config, mustParseDimensions("fingerprint"))
+	byTag := NewGroupBy(om.config, mustParseDimensions("tag"))
+	byError := NewGroupBy(om.config, mustParseDimensions("fingerprint,error"))
+	for _, frame := range frames {
+		byFingerprint.AddFrame(frame)
+		byTag.AddFrame(frame)
+		byError.AddFrame(frame)
+	}
+
+	om.family("mysql_queries", "counter", "Queries by fingerprint.")
+	for _, group := range byFingerprint.Groups() {
+		om.sample("mysql_queries_total", []label{{"fingerprint", group.Values[0]}}, strconv.Itoa(group.Durations.Count))
+	}
+
+	om.family("mysql_query_errors", "counter", "Queries that failed, by fingerprint and error code.")
+	for _, group := range byError.Groups() {
+		om.sample("mysql_query_errors_total", []label{{"fingerprint", group.Values[0]}, {"code", group.Values[1]}}, strconv.Itoa(group.Durations.Count))
+	}
+
+	om.family("mysql_query_duration_seconds", "histogram", "Query latency by fingerprint.")
+	for _, group := range byFingerprint.Groups() {
+		om.histogram("mysql_query_duration_seconds", []label{{"fingerprint", group.Values[0]}}, group.Durations)
+	}
+
+	om.family("mysql_tag_query_duration_seconds", "histogram", "Query latency by tag.")
+	for _, group := range byTag.Groups() {
+		key, value := splitTag(group.Values[0])
+		om.histogram("mysql_tag_query_duration_seconds", []label{{"tag", key}, {"value", value}}, group.Durations)
+	}
+
+	nts := NewNormalizedTransactions(om.config)
+	for _, t := range transactions.Transactions {
+		nts.Add(*t)
+	}
+	keys := make([]string, 0, len(nts.Transactions))
+	for key := range nts.Transactions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	om.family("mysql_transaction_duration_seconds", "histogram", "Transaction duration from BEGIN until the COMMIT response, by transaction fingerprint.")
+	for _, key := range keys {
+		nt := nts.Transactions[key]
+		om.histogram("mysql_transaction_duration_seconds", []label{{"transaction", strings.Join(nt.Fingerprint, "; ")}}, nt.transactionDurations)
+	}
+
+	om.family("mysql_transaction_waste_seconds", "histogram", "Time transactions were open without a query executing, by transaction fingerprint.")
+	for _, key := range keys {
+		nt := nts.Transactions[key]
+		om.histogram("mysql_transaction_waste_seconds", []label{{"transaction", strings.Join(nt.Fingerprint, "; ")}}, nt.wasteDurations)
+	}
+
+	// concurrency over the whole capture, in one bucket
+	timeline := NewTimeline(frames, transactions, io.Discard)
+	dbs := NewDurationBuckets(timeline.End + 1)
+	for _, gauge := range []struct {
+		name      string
+		help      string
+		intervals []Interval
+	}{
+		{"mysql_connections", "Open connections over the capture.", timeline.Connections},
+		{"mysql_queries_in_flight", "Queries executing on the server over the capture.", timeline.Queries},
+		{"mysql_transactions_in_flight", "Connections inside an open transaction over the capture.", timeline.Transactions},
+	} {
+		level := dbs.Levels(gauge.intervals, timeline.End, nil)[0]
+		om.family(gauge.name, "gauge", gauge.help)
+		om.sample(gauge.name, []label{{"stat", "min"}}, strconv.Itoa(level.Min))
+		om.sample(gauge.name, []label{{"stat", "avg"}}, strconv.FormatFloat(level.Avg, 'g', -1, 64))
+		om.sample(gauge.name, []label{{"stat", "max"}}, strconv.Itoa(level.Max))
+	}
+
+	om.printf("# EOF\n")
+	return om.err
+}
+
+// histogram writes the cumulative buckets, count and sum of h
+func (om *OpenMetrics) histogram(name string, labels []label, h *Histogram) {
+	for _, bound := range OpenMetricsBuckets {
+		om.sample(name+"_bucket", append(labels, label{"le", seconds(bound)}), strconv.Itoa(h.CountAtOrBelow(bound)))
+	}
+	om.sample(name+"_bucket", append(labels, label{"le", "+Inf"}), strconv.Itoa(h.Count))
+	om.sample(name+"_count", labels, strconv.Itoa(h.Count))
+	om.sample(name+"_sum", labels, seconds(h.Sum))
+}
+
+func (om *OpenMetrics) family(name string, kind string, help string) {
+	om.printf("# TYPE %s %s\n", name, kind)
+	om.printf("# HELP %s %s\n", name, help)
+}
+
+func (om *OpenMetrics) sample(name string, labels []label, value string) {
+	var builder strings.Builder
+	builder.WriteString(name)
+	if len(labels) > 0 {
+		builder.WriteString("{")
+		for idx, l := range labels {
+			if idx > 0 {
+				builder.WriteString(",")
+			}
+			builder.WriteString(l.name)
+			builder.WriteString(`="`)
+			builder.WriteString(escapeLabelValue(l.value))
+			builder.WriteString(`"`)
+		}
+		builder.WriteString("}")
+	}
+	om.printf("%s %s\n", builder.String(), value)
+}
+
+func (om *OpenMetrics) printf(format string, args ...interface{}) {
+	if om.err == nil {
+		_, om.err = fmt.Fprintf(om.w, format, args...)
+	}
+}
+
+// seconds formats a duration in seconds
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// splitTag splits a tag group value of the form key:value
+func splitTag(tag string) (string, string) {
+	if idx := strings.Index(tag, ":"); idx >= 0 {
+		return tag[:idx], tag[idx+1:]
+	}
+	return tag, ""
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenMetrics(t *testing.T) {
+	fp := NewFrameParser()
+	assert.NoError(t, fp.ParseRawFrames([]rawframe{
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.0", "mysql.query": "SELECT 1 FROM foo /*controller:bar*/"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.002", "mysql.payload": "1"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.1", "mysql.query": "INSERT INTO foo VALUES (1) /*controller:bar*/"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.4", "mysql.error_code": "1213", "mysql.error.message": "Deadlock found", "mysql.response_code": "255"}),
+	}))
+
+	insert := fp.Frames[2].MySQLQuery
+	assert.Equal(t, 1213, insert.ErrorCode)
+	assert.Equal(t, "Deadlock found", insert.Error)
+	assert.Equal(t, "300ms", insert.Duration.String())
+
+	var builder strings.Builder
+	om := NewOpenMetrics(DefaultStatisticsConfig(), &builder)
+	assert.NoError(t, om.Write(fp.Frames, fp.Transactions))
+	lines := strings.Split(builder.String(), "\n")
+
+	assert.Contains(t, lines, `mysql_queries_total{fingerprint="select ? from foo"} 1`)
+	assert.Contains(t, lines, `mysql_query_errors_total{fingerprint="insert into foo values(?+)",code="1213"} 1`)
+	assert.Contains(t, lines, `mysql_query_duration_seconds_bucket{fingerprint="select ? from foo",le="0.001"} 0`)
+	assert.Contains(t, lines, `mysql_query_duration_seconds_bucket{fingerprint="select ? from foo",le="0.0025"} 1`)
+	assert.Contains(t, lines, `mysql_tag_query_duration_seconds_count{tag="controller",value="bar"} 2`)
+	assert.Contains(t, lines, `mysql_connections{stat="max"} 1`)
+	assert.Equal(t, "# EOF", lines[len(lines)-2])
+
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabelValue("a\"b\\c\nd"))
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP span kinds
+const otlpSpanKindClient = 3
+
+// OTLPExport is an OTLP/JSON trace export, as accepted by the OpenTelemetry
+// collector's file receiver and OTLP/HTTP endpoints
+type OTLPExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Links             []otlpLink      `json:"links,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue is a string or an int, the latter encoded as a string like
+// every 64 bit int of OTLP/JSON
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func otlpString(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpInt(i int) otlpAnyValue {
+	s := strconv.Itoa(i)
+	return otlpAnyValue{IntValue: &s}
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+// traceContext is a parsed W3C traceparent
+type traceContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// parseTraceParent parses a W3C traceparent such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceParent(s string) (traceContext, bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceContext{}, false
+	}
+	for _, part := range parts[1:3] {
+		if _, err := hex.DecodeString(part); err != nil || strings.Trim(part, "0") == "" {
+			return traceContext{}, false
+		}
+	}
+	return traceContext{TraceID: parts[1], SpanID: parts[2]}, true
+}
+
+// NewOTLPExport turns every transaction into a span with a child span per
+// query, and every query outside a transaction into a span of its own. Spans
+// join the application trace of the first traceparent tag of their queries;
+// other traceparents become links. IDs of new traces and spans are derived
+// from the capture, so exporting the same capture twice gives the same IDs.
+func NewOTLPExport(frames Frames, transactions Transactions) OTLPExport {
+	// the user each stream logged in as
+	users := make(map[int]string)
+	for _, frame := range frames {
+		if frame.MySQLUser != "" {
+			users[frame.TCPStream] = frame.MySQLUser
+		}
+	}
+
+	// id derives a trace or span ID of size bytes from a frame
+	id := func(size int, kind string, frame *Frame) string {
+		sum := sha256.Sum256([]byte(kind + "/" + strconv.Itoa(frame.TCPStream) + "/" + strconv.Itoa(frame.Number) + "/" + frame.TimeRelative.String()))
+		return hex.EncodeToString(sum[:size])
+	}
+
+	timestamp := func(frame *Frame, d time.Duration) string {
+		start := frame.Time
+		if start.IsZero() {
+			// without frame.time_epoch the capture starts at the epoch
+			start = time.Unix(0, 0).Add(frame.TimeRelative)
+		}
+		return strconv.FormatInt(start.Add(d).UnixNano(), 10)
+	}
+
+	attributes := func(frame *Frame) []otlpAttribute {
+		result := []otlpAttribute{{Key: "db.system", Value: otlpString("mysql")}}
+		if user := users[frame.TCPStream]; user != "" {
+			result = append(result, otlpAttribute{Key: "db.user", Value: otlpString(user)})
+		}
+		if frame.DstHost != "" {
+			result = append(result, otlpAttribute{Key: "net.sock.peer.addr", Value: otlpString(frame.DstHost)})
+		}
+		if frame.DstPort != 0 {
+			result = append(result, otlpAttribute{Key: "net.peer.port", Value: otlpInt(frame.DstPort)})
+		}
+		return result
+	}
+
+	// context returns the trace the frames belong to, and links to the
+	// traces of any other traceparents
+	context := func(frames []*Frame) (traceContext, bool, []otlpLink) {
+		var parent traceContext
+		found := false
+		var links []otlpLink
+		for _, frame := range frames {
+			tc, ok := parseTraceParent(frame.MySQLQuery.TraceParent)
+			switch {
+			case !ok:
+			case !found:
+				parent, found = tc, true
+			case tc != parent:
+				links = append(links, otlpLink{TraceID: tc.TraceID, SpanID: tc.SpanID})
+			}
+		}
+		return parent, found, links
+	}
+
+	querySpan := func(frame *Frame, traceID string, parentSpanID string) otlpSpan {
+		span := otlpSpan{
+			TraceID:           traceID,
+			SpanID:            id(8, "query", frame),
+			ParentSpanID:      parentSpanID,
+			Name:              frame.MySQLQuery.Fingerprint,
+			Kind:              otlpSpanKindClient,
+			StartTimeUnixNano: timestamp(frame, 0),
+			EndTimeUnixNano:   timestamp(frame, frame.MySQLQuery.Duration),
+			Attributes:        append(attributes(frame), otlpAttribute{Key: "db.statement", Value: otlpString(frame.MySQLQuery.Fingerprint)}),
+		}
+		if frame.MySQLQuery.RequestID != "" {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: "request_id", Value: otlpString(frame.MySQLQuery.RequestID)})
+		}
+
+		keys := make([]string, 0, len(frame.MySQLQuery.Tags))
+		for k := range frame.MySQLQuery.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: "db.mysql.tag." + k, Value: otlpString(frame.MySQLQuery.Tags[k])})
+		}
+
+		return span
+	}
+
+	var spans []otlpSpan
+	inTransaction := make(map[*Frame]bool)
+
+	ids := make([]int, 0, len(transactions.Transactions))
+	for txid := range transactions.Transactions {
+		ids = append(ids, txid)
+	}
+	sort.Ints(ids)
+
+	for _, txid := range ids {
+		t := transactions.Transactions[txid]
+		first := t.Frames[0]
+		parent, found, links := context(t.Frames)
+
+		traceID := id(16, "trace", first)
+		if found {
+			traceID = parent.TraceID
+		}
+
+		span := otlpSpan{
+			TraceID:           traceID,
+			SpanID:            id(8, "transaction", first),
+			ParentSpanID:      parent.SpanID,
+			Name:              "transaction",
+			Kind:              otlpSpanKindClient,
+			StartTimeUnixNano: timestamp(first, 0),
+			EndTimeUnixNano:   timestamp(first, t.TotalDuration()),
+			Attributes:        attributes(first),
+			Links:             links,
+		}
+		spans = append(spans, span)
+
+		for _, frame := range t.Frames {
+			inTransaction[frame] = true
+			spans = append(spans, querySpan(frame, traceID, span.SpanID))
+		}
+	}
+
+	for _, frame := range frames {
+		if frame.MySQLQuery.Fingerprint == "" || inTransaction[frame] {
+			continue
+		}
+
+		parent, found, _ := context([]*Frame{frame})
+		traceID := id(16, "trace", frame)
+		if found {
+			traceID = parent.TraceID
+		}
+		spans = append(spans, querySpan(frame, traceID, parent.SpanID))
+	}
+
+	// transactions stay ahead of the queries they enclose
+	sort.SliceStable(spans, func(i, j int) bool {
+		a, _ := strconv.ParseInt(spans[i].StartTimeUnixNano, 10, 64)
+		b, _ := strconv.ParseInt(spans[j].StartTimeUnixNano, 10, 64)
+		return a < b
+	})
+
+	return OTLPExport{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpString("mysql")}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "mysql1-analysis"},
+			Spans: spans,
+		}},
+	}}}
+}
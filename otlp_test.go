@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTLPExport(t *testing.T) {
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	frames, transactions := buildTransactions(t, 1, [][]timedQuery{{
+		{"BEGIN", 10 * time.Millisecond},
+		{"SELECT 1 FROM foo /*controller:bar,request_id:abc,traceparent:" + traceParent + "*/", 20 * time.Millisecond},
+		{"COMMIT", 30 * time.Millisecond},
+	}})
+	for _, frame := range frames {
+		frame.DstHost, frame.DstPort = "10.0.0.1", 3306
+	}
+	// the login of the connection
+	frames = append(Frames{{TCPStream: 1, MySQLUser: "app"}}, frames...)
+
+	query := frames[2].MySQLQuery
+	assert.Equal(t, "abc", query.RequestID)
+	assert.Equal(t, traceParent, query.TraceParent)
+	assert.Equal(t, map[string]string{"controller": "bar"}, query.Tags)
+
+	export := NewOTLPExport(frames, transactions)
+	spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Len(t, spans, 4)
+
+	// the transaction joins the application trace
+	assert.Equal(t, "transaction", spans[0].Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+	assert.Equal(t, "10000000", spans[0].StartTimeUnixNano)
+	assert.Equal(t, "31000000", spans[0].EndTimeUnixNano)
+
+	for _, span := range spans[1:] {
+		assert.Equal(t, spans[0].TraceID, span.TraceID)
+		assert.Equal(t, spans[0].SpanID, span.ParentSpanID)
+	}
+
+	assert.Equal(t, "select ? from foo", spans[2].Name)
+	assert.Contains(t, spans[2].Attributes, otlpAttribute{Key: "db.system", Value: otlpString("mysql")})
+	assert.Contains(t, spans[2].Attributes, otlpAttribute{Key: "db.statement", Value: otlpString("select ? from foo")})
+	assert.Contains(t, spans[2].Attributes, otlpAttribute{Key: "db.user", Value: otlpString("app")})
+	assert.Contains(t, spans[2].Attributes, otlpAttribute{Key: "net.sock.peer.addr", Value: otlpString("10.0.0.1")})
+	assert.Contains(t, spans[2].Attributes, otlpAttribute{Key: "net.peer.port", Value: otlpInt(3306)})
+	assert.Contains(t, spans[2].Attributes, otlpAttribute{Key: "request_id", Value: otlpString("abc")})
+	assert.Contains(t, spans[2].Attributes, otlpAttribute{Key: "db.mysql.tag.controller", Value: otlpString("bar")})
+
+	b, err := json.Marshal(otlpAttribute{Key: "net.peer.port", Value: otlpInt(3306)})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"key":"net.peer.port","value":{"intValue":"3306"}}`, string(b))
+
+	// the same capture gives the same ids
+	assert.Equal(t, export, NewOTLPExport(frames, transactions))
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTMLReport is a self-contained HTML report of a capture, with every chart
+// inline and no network access needed to view it
+type HTMLReport struct {
+	Percentiles  []string
+	Quality      []reportValue
+	Charts       []template.HTML
+	Interval     time.Duration
+	Fingerprints []reportFingerprint
+	Transactions []reportTransaction
+	Errors       []reportError
+}
+
+type reportValue struct {
+	Name  string
+	Value string
+}
+
+type reportFingerprint struct {
+	Fingerprint string
+	Count       int
+	// durations in milliseconds
+	Total       string
+	Mean        string
+	Percentiles []string
+	Max         string
+}
+
+type reportTransaction struct {
+	Fingerprint     []string
+	Example         []string
+	Tags            []string
+	Count           int
+	TotalWaste      string
+	MeanWaste       string
+	WastePercentage string
+}
+
+type reportError struct {
+	Fingerprint string
+	Code        string
+	Message     string
+	Count       int
+}
+
+// NewHTMLReport builds the report, with the concurrency time series bucketed by interval
+func NewHTMLReport(config StatisticsConfig, fp *FrameParser, interval time.Duration) (HTMLReport, error) {
+	report := HTMLReport{Interval: interval}
+	for _, p := range config.Percentiles {
+		report.Percentiles = append(report.Percentiles, Percentile{Percentile: p}.Name())
+	}
+
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	timeline := NewTimeline(fp.Frames, fp.Transactions, io.Discard)
+
+	// streams with traffic after they were closed point at missing or reordered frames
+	streams := NewStreams(0)
+	for _, frame := range fp.Frames {
+		streams.AddFrame(frame)
+	}
+	late := 0
+	for _, stream := range streams.Streams() {
+		if stream.Closed && stream.LastOpen > stream.ClosedAt {
+			late++
+		}
+	}
+
+	report.Quality = []reportValue{
+		{"Capture duration", timeline.End.String()},
+		{"Frames", strconv.Itoa(len(fp.Frames))},
+		{"Streams", strconv.Itoa(len(timeline.Connections))},
+		{"Queries", strconv.Itoa(fp.Quality.Queries)},
+		{"Queries without a response", strconv.Itoa(fp.Quality.Unanswered)},
+		{"Transactions", strconv.Itoa(len(fp.Transactions.Transactions))},
+		{"Frames after a lost segment", strconv.Itoa(fp.Quality.LostSegments)},
+		{"Transactions dropped for a lost segment", strconv.Itoa(fp.Quality.LostTransactions)},
+		{"Transactions unfinished at the end of the capture", strconv.Itoa(fp.Quality.UnfinishedTransactions)},
+		{"Streams with traffic after they were closed", strconv.Itoa(late)},
+	}
+
+	dbs := NewDurationBuckets(interval)
+	series := dbs.Series(timeline, nil)
+	for _, chart := range []struct {
+		name   string
+		levels func(bucket ConcurrencyBucket) Level
+	}{
+		{"Open connections", func(bucket ConcurrencyBucket) Level { return bucket.Connections }},
+		{"Queries in flight", func(bucket ConcurrencyBucket) Level { return bucket.Queries }},
+		{"Open transactions", func(bucket ConcurrencyBucket) Level { return bucket.Transactions }},
+	} {
+		var levels []Level
+		for _, bucket := range series.Buckets {
+			levels = append(levels, chart.levels(bucket))
+		}
+		report.Charts = append(report.Charts, concurrencyChart(chart.name, interval, levels))
+	}
+
+	byFingerprint := NewGroupBy(config, mustParseDimensions("fingerprint"))
+	byError := NewGroupBy(config, mustParseDimensions("fingerprint,error"))
+	messages := make(map[string]string)
+	for _, frame := range fp.Frames {
+		byFingerprint.AddFrame(frame)
+		byError.AddFrame(frame)
+		if frame.MySQLQuery.ErrorCode != 0 {
+			messages[frame.MySQLQuery.Fingerprint+"\t"+strconv.Itoa(frame.MySQLQuery.ErrorCode)] = frame.MySQLQuery.Error
+		}
+	}
+
+	groups := byFingerprint.Groups()
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Durations.Sum > groups[j].Durations.Sum
+	})
+	for _, group := range groups {
+		ts, err := NewTimeStatistics(group.Durations, config.Percentiles)
+		if err != nil {
+			return report, err
+		}
+
+		row := reportFingerprint{
+			Fingerprint: group.Values[0],
+			Count:       ts.Count,
+			Total:       ms(ts.Sum),
+			Mean:        ms(ts.Mean),
+			Max:         ms(ts.Max),
+		}
+		for _, p := range ts.Percentiles {
+			row.Percentiles = append(row.Percentiles, ms(p.Value))
+		}
+		report.Fingerprints = append(report.Fingerprints, row)
+	}
+
+	for _, group := range byError.Groups() {
+		report.Errors = append(report.Errors, reportError{
+			Fingerprint: group.Values[0],
+			Code:        group.Values[1],
+			Message:     messages[strings.Join(group.Values, "\t")],
+			Count:       group.Durations.Count,
+		})
+	}
+
+	nts := NewNormalizedTransactions(config)
+	for _, t := range fp.Transactions.Transactions {
+		nts.Add(*t)
+	}
+	ranked := make([]*NormalizedTransaction, 0, len(nts.Transactions))
+	for _, nt := range nts.Transactions {
+		ranked = append(ranked, nt)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].wasteDurations.Sum != ranked[j].wasteDurations.Sum {
+			return ranked[i].wasteDurations.Sum > ranked[j].wasteDurations.Sum
+		}
+		return strings.Join(ranked[i].Fingerprint, "\n") < strings.Join(ranked[j].Fingerprint, "\n")
+	})
+
+	for _, nt := range ranked {
+		row := reportTransaction{
+			Fingerprint: nt.Fingerprint,
+			Example:     nt.Example,
+			Count:       nt.wasteDurations.Count,
+			TotalWaste:  ms(nt.wasteDurations.Sum),
+			MeanWaste:   ms(nt.wasteDurations.Mean()),
+		}
+		if nt.transactionDurations.Sum > 0 {
+			row.WastePercentage = strconv.FormatFloat(float64(nt.wasteDurations.Sum)/float64(nt.transactionDurations.Sum)*100, 'f', 1, 64)
+		}
+		for tag := range nt.tags {
+			row.Tags = append(row.Tags, tag)
+		}
+		sort.Strings(row.Tags)
+		report.Transactions = append(report.Transactions, row)
+	}
+
+	return report, nil
+}
+
+// Write writes the report as one HTML document
+func (r *HTMLReport) Write(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// concurrencyChart draws the average and max level of each bucket as an inline SVG line chart
+func concurrencyChart(name string, interval time.Duration, levels []Level) template.HTML {
+	const (
+		width  = 900.0
+		height = 160.0
+		margin = 30.0
+	)
+
+	peak := 1
+	for _, level := range levels {
+		if level.Max > peak {
+			peak = level.Max
+		}
+	}
+
+	x := func(idx int) float64 {
+		if len(levels) <= 1 {
+			return margin
+		}
+		return margin + float64(idx)/float64(len(levels)-1)*(width-2*margin)
+	}
+	y := func(v float64) float64 {
+		return height - margin - v/float64(peak)*(height-2*margin)
+	}
+
+	var avg, max strings.Builder
+	for idx, level := range levels {
+		fmt.Fprintf(&avg, "%.1f,%.1f ", x(idx), y(level.Avg))
+		fmt.Fprintf(&max, "%.1f,%.1f ", x(idx), y(float64(level.Max)))
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `<svg width="%.0f" height="%.0f" font-size="11">`, width, height)
+	fmt.Fprintf(&builder, `<text x="%.0f" y="14" font-weight="bold">%s</text>`, margin, template.HTMLEscapeString(name))
+	fmt.Fprintf(&builder, `<line x1="%.0f" y1="%.0f" x2="%.0f" y2="%.0f" stroke="#999"/>`, margin, height-margin, width-margin, height-margin)
+	fmt.Fprintf(&builder, `<text x="2" y="%.1f">%d</text><text x="2" y="%.1f">0</text>`, y(float64(peak))+4, peak, height-margin+4)
+	fmt.Fprintf(&builder, `<text x="%.0f" y="%.0f">0</text><text x="%.0f" y="%.0f" text-anchor="end">%s</text>`,
+		margin, height-margin+14, width-margin, height-margin+14, template.HTMLEscapeString((time.Duration(len(levels)) * interval).String()))
+	fmt.Fprintf(&builder, `<polyline fill="none" stroke="#d9534f" points="%s"><title>max</title></polyline>`, strings.TrimSpace(max.String()))
+	fmt.Fprintf(&builder, `<polyline fill="none" stroke="#4a90d9" points="%s"><title>avg</title></polyline>`, strings.TrimSpace(avg.String()))
+	builder.WriteString(`</svg>`)
+
+	return template.HTML(builder.String())
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>MySQL capture report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; cursor: pointer; }
+td.number { text-align: right; }
+pre { margin: 0; white-space: pre-wrap; }
+input.filter { margin-bottom: 0.5em; width: 30em; }
+</style>
+</head>
+<body>
+<h1>MySQL capture report</h1>
+
+<h2>Capture quality</h2>
+<table>
+{{range .Quality}}<tr><th>{{.Name}}</th><td class="number">{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Concurrency</h2>
+<p>Average (blue) and max (red) per {{.Interval}} bucket.</p>
+{{range .Charts}}<div>{{.}}</div>
+{{end}}
+
+<h2>Top fingerprints by total time</h2>
+<input class="filter" data-table="fingerprints" placeholder="filter">
+<table id="fingerprints" class="sortable">
+<thead><tr><th>Fingerprint</th><th>Count</th><th>Total ms</th><th>Mean ms</th>{{range .Percentiles}}<th>{{.}} ms</th>{{end}}<th>Max ms</th></tr></thead>
+<tbody>
+{{range .Fingerprints}}<tr><td>{{.Fingerprint}}</td><td class="number">{{.Count}}</td><td class="number">{{.Total}}</td><td class="number">{{.Mean}}</td>{{range .Percentiles}}<td class="number">{{.}}</td>{{end}}<td class="number">{{.Max}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<h2>Transactions by waste</h2>
+<input class="filter" data-table="transactions" placeholder="filter">
+<table id="transactions" class="sortable">
+<thead><tr><th>Fingerprint</th><th>Example</th><th>Tags</th><th>Count</th><th>Total waste ms</th><th>Mean waste ms</th><th>Waste %</th></tr></thead>
+<tbody>
+{{range .Transactions}}<tr><td><pre>{{range .Fingerprint}}{{.}}
+{{end}}</pre></td><td><pre>{{range .Example}}{{.}}
+{{end}}</pre></td><td>{{range .Tags}}{{.}}<br>{{end}}</td><td class="number">{{.Count}}</td><td class="number">{{.TotalWaste}}</td><td class="number">{{.MeanWaste}}</td><td class="number">{{.WastePercentage}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<h2>Errors</h2>
+<input class="filter" data-table="errors" placeholder="filter">
+<table id="errors" class="sortable">
+<thead><tr><th>Fingerprint</th><th>Code</th><th>Message</th><th>Count</th></tr></thead>
+<tbody>
+{{range .Errors}}<tr><td>{{.Fingerprint}}</td><td class="number">{{.Code}}</td><td>{{.Message}}</td><td class="number">{{.Count}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<script>
+document.querySelectorAll("table.sortable th").forEach(function (th) {
+  th.addEventListener("click", function () {
+    var table = th.closest("table");
+    var tbody = table.tBodies[0];
+    var idx = Array.prototype.indexOf.call(th.parentNode.children, th);
+    var asc = th.dataset.order !== "asc";
+    th.dataset.order = asc ? "asc" : "desc";
+    var rows = Array.prototype.slice.call(tbody.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[idx].textContent, y = b.cells[idx].textContent;
+      var nx = parseFloat(x), ny = parseFloat(y);
+      var cmp = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+      return asc ? cmp : -cmp;
+    });
+    rows.forEach(function (row) { tbody.appendChild(row); });
+  });
+});
+document.querySelectorAll("input.filter").forEach(function (input) {
+  input.addEventListener("input", function () {
+    var text = input.value.toLowerCase();
+    var rows = document.getElementById(input.dataset.table).tBodies[0].rows;
+    Array.prototype.forEach.call(rows, function (row) {
+      row.style.display = row.textContent.toLowerCase().indexOf(text) >= 0 ? "" : "none";
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTMLReport(t *testing.T) {
+	fp := NewFrameParser()
+	assert.NoError(t, fp.ParseRawFrames([]rawframe{
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.0", "mysql.query": "BEGIN"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.001", "mysql.response_code": "0"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.1", "mysql.query": "INSERT INTO foo VALUES (1) /*controller:<bar>*/"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.2", "mysql.error_code": "1062", "mysql.error.message": "Duplicate entry", "mysql.response_code": "255"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.3", "mysql.query": "ROLLBACK"}),
+		rawFrame(map[string]string{"tcp.stream": "1", "frame.time_relative": "0.301", "mysql.response_code": "0"}),
+		// a query whose response was not captured, in a transaction still open at the end
+		rawFrame(map[string]string{"tcp.stream": "2", "frame.time_relative": "0.4", "mysql.query": "BEGIN"}),
+	}))
+
+	assert.Equal(t, CaptureQuality{Queries: 4, Unanswered: 1, UnfinishedTransactions: 1}, fp.Quality)
+
+	report, err := NewHTMLReport(DefaultStatisticsConfig(), &fp, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Len(t, report.Charts, 3)
+	assert.Equal(t, "insert into foo values(?+)", report.Fingerprints[0].Fingerprint)
+	assert.Equal(t, []reportError{{Fingerprint: "insert into foo values(?+)", Code: "1062", Message: "Duplicate entry", Count: 1}}, report.Errors)
+	assert.Len(t, report.Transactions, 1)
+	assert.Equal(t, "199.000", report.Transactions[0].TotalWaste)
+
+	var builder strings.Builder
+	assert.NoError(t, report.Write(&builder))
+	html := builder.String()
+	assert.Contains(t, html, "<td>Duplicate entry</td>")
+	assert.Contains(t, html, "controller:&lt;bar&gt;")
+	assert.NotContains(t, html, "http://", "the report must not load anything over the network")
+}
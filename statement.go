@@ -0,0 +1,289 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// the types of statements
+const (
+	StatementSelect  = "SELECT"
+	StatementInsert  = "INSERT"
+	StatementUpdate  = "UPDATE"
+	StatementDelete  = "DELETE"
+	StatementReplace = "REPLACE"
+	StatementDDL     = "DDL"
+	StatementOther   = "OTHER"
+)
+
+// Statement is the type of a query and the tables it reads and writes. A
+// table that is written is not also listed as read.
+type Statement struct {
+	Type   string
+	Reads  []string
+	Writes []string
+}
+
+// Tables returns every table of the statement, written ones first
+func (s *Statement) Tables() []string {
+	return append(append([]string{}, s.Writes...), s.Reads...)
+}
+
+// StatementCache parses every fingerprint once
+type StatementCache map[string]Statement
+
+func (c StatementCache) Parse(fingerprint string) Statement {
+	statement, ok := c[fingerprint]
+	if !ok {
+		statement = ParseStatement(fingerprint)
+		c[fingerprint] = statement
+	}
+	return statement
+}
+
+// keywords that end a table reference instead of naming its alias
+var tableRefKeywords = map[string]bool{
+	"where": true, "on": true, "using": true, "join": true, "inner": true, "left": true,
+	"right": true, "outer": true, "cross": true, "natural": true, "straight_join": true,
+	"group": true, "order": true, "limit": true, "set": true, "having": true, "union": true,
+	"for": true, "lock": true, "force": true, "use": true, "ignore": true, "partition": true,
+	"window": true, "values": true, "value": true, "select": true, "into": true, "procedure": true,
+	"to": true, "from": true,
+}
+
+// ParseStatement extracts the statement type and tables of a query, ideally
+// its fingerprint. It understands enough of the grammar for the usual DML and
+// DDL and ignores what it does not.
+func ParseStatement(query string) Statement {
+	tokens := tokenizeStatement(strings.ToLower(query))
+	statement := Statement{Type: StatementOther}
+	if len(tokens) == 0 {
+		return statement
+	}
+
+	reads := make(map[string]bool)
+	writes := make(map[string]bool)
+	aliases := make(map[string]string)
+
+	// every table read by a FROM or JOIN, including subqueries. The FROM of
+	// function calls like EXTRACT(YEAR FROM col) or TRIM(x FROM col) is not
+	// followed by a table.
+	var calls []bool // whether each open parenthesis is a function call
+	for idx, token := range tokens {
+		switch token {
+		case "(":
+			call := idx > 0 && isWord(tokens[idx-1]) && !tableRefKeywords[tokens[idx-1]] &&
+				(idx+1 >= len(tokens) || tokens[idx+1] != "select" && tokens[idx+1] != "with" && tokens[idx+1] != "(")
+			calls = append(calls, call)
+			continue
+		case ")":
+			if len(calls) > 0 {
+				calls = calls[:len(calls)-1]
+			}
+			continue
+		case "from", "join", "straight_join":
+			if len(calls) > 0 && calls[len(calls)-1] {
+				continue
+			}
+		default:
+			continue
+		}
+		for _, ref := range parseTableRefs(tokens, idx+1, token == "from") {
+			reads[ref.name] = true
+			aliases[ref.alias] = ref.name
+		}
+	}
+
+	switch tokens[0] {
+	case "select", "with", "(":
+		statement.Type = StatementSelect
+	case "insert", "replace":
+		statement.Type = StatementInsert
+		if tokens[0] == "replace" {
+			statement.Type = StatementReplace
+		}
+		idx := skipWords(tokens, 1, "low_priority", "delayed", "high_priority", "ignore", "into")
+		if name := tableName(tokens, idx); name != "" {
+			writes[name] = true
+		}
+	case "update":
+		statement.Type = StatementUpdate
+		// the tables listed before any join are updated, joined tables are read
+		for _, ref := range parseTableRefs(tokens, skipWords(tokens, 1, "low_priority", "ignore"), true) {
+			writes[ref.name] = true
+		}
+	case "delete":
+		statement.Type = StatementDelete
+		idx := skipWords(tokens, 1, "low_priority", "quick", "ignore")
+		if idx < len(tokens) && tokens[idx] != "from" {
+			// multi-table delete names the tables or aliases deleted from before FROM
+			for ; idx < len(tokens) && tokens[idx] != "from" && tokens[idx] != "using"; idx++ {
+				if tokens[idx] == "," {
+					continue
+				}
+				name := strings.TrimSuffix(tokens[idx], ".*")
+				if table, ok := aliases[name]; ok {
+					name = table
+				}
+				writes[name] = true
+			}
+		} else if refs := parseTableRefs(tokens, idx+1, true); len(refs) > 0 {
+			writes[refs[0].name] = true
+		}
+	case "create", "alter", "drop", "truncate", "rename":
+		statement.Type = StatementDDL
+		for _, name := range ddlTables(tokens) {
+			writes[name] = true
+		}
+	}
+
+	for name := range writes {
+		delete(reads, name)
+	}
+	statement.Reads = sortedKeys(reads)
+	statement.Writes = sortedKeys(writes)
+	return statement
+}
+
+// ddlTables returns the tables a DDL statement changes
+func ddlTables(tokens []string) []string {
+	var result []string
+
+	// CREATE INDEX and DROP INDEX name the table after ON
+	for idx, token := range tokens {
+		if token == "on" {
+			if name := tableName(tokens, idx+1); name != "" {
+				return []string{name}
+			}
+		}
+	}
+
+	idx := skipWords(tokens, 1, "temporary", "online", "ignore")
+	if idx >= len(tokens) || tokens[idx] != "table" && tokens[0] != "truncate" {
+		return nil
+	}
+	idx = skipWords(tokens, idx, "table", "if", "not", "exists")
+
+	// DROP TABLE and RENAME TABLE list several tables
+	for idx < len(tokens) {
+		name := tableName(tokens, idx)
+		if name == "" {
+			break
+		}
+		result = append(result, name)
+		idx++
+		if tokens[0] != "drop" && tokens[0] != "rename" {
+			break
+		}
+		if idx < len(tokens) && (tokens[idx] == "," || tokens[idx] == "to") {
+			idx++
+			continue
+		}
+		break
+	}
+	return result
+}
+
+type tableRef struct {
+	name  string
+	alias string
+}
+
+// parseTableRefs parses the table references starting at idx, a comma
+// separated list if list is set. Derived tables are skipped, their own
+// FROM is parsed separately.
+func parseTableRefs(tokens []string, idx int, list bool) []tableRef {
+	var result []tableRef
+	for idx < len(tokens) {
+		name := tableName(tokens, idx)
+		if name == "" {
+			break
+		}
+		ref := tableRef{name: name, alias: name}
+		idx++
+		if idx < len(tokens) && tokens[idx] == "as" {
+			idx++
+		}
+		if idx < len(tokens) && isWord(tokens[idx]) && !tableRefKeywords[tokens[idx]] {
+			ref.alias = tokens[idx]
+			idx++
+		}
+		result = append(result, ref)
+
+		if !list || idx >= len(tokens) || tokens[idx] != "," {
+			break
+		}
+		idx++
+	}
+	return result
+}
+
+// tableName returns the table name at idx, or "" if there is none
+func tableName(tokens []string, idx int) string {
+	if idx >= len(tokens) || !isWord(tokens[idx]) || tableRefKeywords[tokens[idx]] || tokens[idx] == "dual" {
+		return ""
+	}
+	return tokens[idx]
+}
+
+func skipWords(tokens []string, idx int, words ...string) int {
+	for idx < len(tokens) {
+		skipped := false
+		for _, word := range words {
+			if tokens[idx] == word {
+				skipped = true
+				break
+			}
+		}
+		if !skipped {
+			break
+		}
+		idx++
+	}
+	return idx
+}
+
+func isWord(token string) bool {
+	return token != "" && token != "?" && strings.IndexAny(token[:1], "(),;=<>!+-*/%?'\"") < 0
+}
+
+// tokenizeStatement splits a query into words, with backticks removed, and
+// single character punctuation
+func tokenizeStatement(query string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '`':
+			continue
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '$', r == '.', r >= 0x80:
+			word.WriteRune(r)
+		case r == '*' && strings.HasSuffix(word.String(), "."):
+			word.WriteRune(r)
+		case r == ' ', r == '\t', r == '\n', r == '\r':
+			flush()
+		default:
+			flush()
+			tokens = append(tokens, string(r))
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+func sortedKeys(m map[string]bool) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatement(t *testing.T) {
+	for _, tc := range []struct {
+		query     string
+		statement Statement
+	}{
+		{"SELECT * FROM users WHERE id = 1", Statement{StatementSelect, []string{"users"}, []string{}}},
+		{"select u.id from `users` u, orgs join repos as r on r.owner_id = u.id where u.id in (select user_id from members)", Statement{StatementSelect, []string{"members", "orgs", "repos", "users"}, []string{}}},
+		{"SELECT 1", Statement{StatementSelect, []string{}, []string{}}},
+		{"INSERT IGNORE INTO audit (a, b) SELECT a, b FROM log", Statement{StatementInsert, []string{"log"}, []string{"audit"}}},
+		{"REPLACE INTO counters VALUES (1)", Statement{StatementReplace, []string{}, []string{"counters"}}},
+		{"UPDATE users u JOIN repos r ON r.owner_id = u.id SET u.seen = 1", Statement{StatementUpdate, []string{"repos"}, []string{"users"}}},
+		{"DELETE FROM sessions WHERE user_id = 1", Statement{StatementDelete, []string{}, []string{"sessions"}}},
+		{"DELETE s FROM sessions s JOIN users u ON u.id = s.user_id", Statement{StatementDelete, []string{"users"}, []string{"sessions"}}},
+		{"ALTER TABLE db.users ADD COLUMN x int", Statement{StatementDDL, []string{}, []string{"db.users"}}},
+		{"CREATE INDEX idx ON users (name)", Statement{StatementDDL, []string{}, []string{"users"}}},
+		{"DROP TABLE IF EXISTS a, b", Statement{StatementDDL, []string{}, []string{"a", "b"}}},
+		{"RENAME TABLE a TO b", Statement{StatementDDL, []string{}, []string{"a", "b"}}},
+		{"TRUNCATE users", Statement{StatementDDL, []string{}, []string{"users"}}},
+		{"begin", Statement{StatementOther, []string{}, []string{}}},
+		// FROM within function calls
+		{"SELECT EXTRACT(YEAR FROM created_at) FROM foo", Statement{StatementSelect, []string{"foo"}, []string{}}},
+		{"SELECT TRIM(LEADING 'x' FROM name), SUBSTRING(title FROM 2) FROM foo WHERE id IN (SELECT foo_id FROM bar)", Statement{StatementSelect, []string{"bar", "foo"}, []string{}}},
+		{"SELECT * FROM foo WHERE EXISTS (SELECT 1 FROM bar WHERE bar.id = foo.id)", Statement{StatementSelect, []string{"bar", "foo"}, []string{}}},
+	} {
+		assert.Equal(t, tc.statement, ParseStatement(tc.query), tc.query)
+	}
+}
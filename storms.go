@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"time"
+)
+
+// StormConfig configures what counts as a connection storm
+type StormConfig struct {
+	// Interval is the bucket size new connections and resets are counted in
+	Interval time.Duration
+	// Factor is how many times the baseline a bucket must exceed to be part of a storm
+	Factor float64
+	// Minimum is the fewest connections or resets in a bucket that can be a storm,
+	// so a quiet capture does not flag every other connection
+	Minimum int
+}
+
+func DefaultStormConfig() StormConfig {
+	return StormConfig{Interval: time.Second, Factor: 3, Minimum: 5}
+}
+
+// the kinds of storms
+const (
+	StormConnections = "connections"
+	StormResets      = "resets"
+)
+
+// Storm is a run of consecutive buckets with a burst of new connections or resets
+type Storm struct {
+	Kind  string
+	Start time.Duration
+	End   time.Duration
+	// Count is the number of new connections or resets during the storm
+	Count int
+	// Baseline is the typical number per bucket
+	Baseline float64
+	// Clients and Tags count the connections of the storm by client host and
+	// by the tags of their queries
+	Clients map[string]int
+	Tags    map[string]int
+	// Latency is of the queries sent during the storm
+	Latency *Histogram
+}
+
+// StormReport holds the storms of a capture, plus the latency of the queries
+// outside of any storm to compare them with
+type StormReport struct {
+	config  StatisticsConfig
+	Storms  []*Storm
+	Latency *Histogram
+	// AlreadyOpen counts the connections that were open when the capture
+	// started, which are not new connections
+	AlreadyOpen int
+}
+
+// NewStormReport detects connection storms and reset storms in the capture
+func NewStormReport(config StatisticsConfig, sc StormConfig, frames Frames, serverPort int) StormReport {
+	report := StormReport{config: config, Latency: NewHistogram(config.RelativeError)}
+
+	streams := NewStreams(serverPort)
+	for _, frame := range frames {
+		streams.AddFrame(frame)
+	}
+
+	dbs := NewDurationBuckets(sc.Interval)
+	var end time.Duration
+	for _, frame := range frames {
+		if frame.TimeRelative > end {
+			end = frame.TimeRelative
+		}
+	}
+	count := dbs.bucket(end) + 1
+
+	// the streams opened and reset in each bucket
+	opened := make([][]*Stream, count)
+	reset := make([][]*Stream, count)
+	for _, stream := range streams.Streams() {
+		if stream.Started {
+			opened[dbs.bucket(stream.First)] = append(opened[dbs.bucket(stream.First)], stream)
+		} else {
+			report.AlreadyOpen++
+		}
+		if streams.ClosedBy(stream) == ClosedByRST {
+			reset[dbs.bucket(stream.ClosedAt)] = append(reset[dbs.bucket(stream.ClosedAt)], stream)
+		}
+	}
+
+	// tags of the queries of each stream
+	tags := make(map[int]map[string]bool)
+	for _, frame := range frames {
+		for k, v := range frame.MySQLQuery.Tags {
+			if tags[frame.TCPStream] == nil {
+				tags[frame.TCPStream] = make(map[string]bool)
+			}
+			tags[frame.TCPStream][k+":"+v] = true
+		}
+	}
+
+	for _, kind := range []struct {
+		name    string
+		buckets [][]*Stream
+	}{
+		{StormConnections, opened},
+		{StormResets, reset},
+	} {
+		baseline := medianCount(kind.buckets)
+
+		var storm *Storm
+		for idx, bucket := range kind.buckets {
+			if len(bucket) < sc.Minimum || float64(len(bucket)) <= sc.Factor*baseline {
+				storm = nil
+				continue
+			}
+
+			if storm == nil {
+				storm = &Storm{
+					Kind:     kind.name,
+					Start:    time.Duration(idx) * sc.Interval,
+					Baseline: baseline,
+					Clients:  make(map[string]int),
+					Tags:     make(map[string]int),
+					Latency:  NewHistogram(config.RelativeError),
+				}
+				report.Storms = append(report.Storms, storm)
+			}
+			storm.End = time.Duration(idx+1) * sc.Interval
+			storm.Count += len(bucket)
+
+			for _, stream := range bucket {
+				client := "unknown"
+				if host, _, err := net.SplitHostPort(stream.Client); err == nil {
+					client = host
+				}
+				storm.Clients[client]++
+				for tag := range tags[stream.ID] {
+					storm.Tags[tag]++
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(report.Storms, func(i, j int) bool {
+		return report.Storms[i].Start < report.Storms[j].Start
+	})
+
+	for _, frame := range frames {
+		if frame.MySQLQuery.Fingerprint == "" {
+			continue
+		}
+
+		during := false
+		for _, storm := range report.Storms {
+			if frame.TimeRelative >= storm.Start && frame.TimeRelative < storm.End {
+				storm.Latency.Add(frame.MySQLQuery.Duration)
+				during = true
+			}
+		}
+		if !during {
+			report.Latency.Add(frame.MySQLQuery.Duration)
+		}
+	}
+
+	return report
+}
+
+// medianCount returns the median number of streams per bucket
+func medianCount(buckets [][]*Stream) float64 {
+	if len(buckets) == 0 {
+		return 0
+	}
+
+	counts := make([]int, len(buckets))
+	for idx, bucket := range buckets {
+		counts[idx] = len(bucket)
+	}
+	sort.Ints(counts)
+
+	middle := len(counts) / 2
+	if len(counts)%2 == 0 {
+		return float64(counts[middle-1]+counts[middle]) / 2
+	}
+	return float64(counts[middle])
+}
+
+func (sr *StormReport) MarshalJSON() ([]byte, error) {
+	type storm struct {
+		Kind     string          `json:"kind"`
+		Start    float64         `json:"start"`
+		End      float64         `json:"end"`
+		Count    int             `json:"count"`
+		Baseline float64         `json:"baseline"`
+		Clients  map[string]int  `json:"clients"`
+		Tags     map[string]int  `json:"tags"`
+		Latency  *TimeStatistics `json:"latency,omitempty"`
+	}
+
+	data := struct {
+		Storms      []storm         `json:"storms"`
+		AlreadyOpen int             `json:"already_open"`
+		Latency     *TimeStatistics `json:"latency_outside_storms,omitempty"`
+	}{
+		Storms:      []storm{},
+		AlreadyOpen: sr.AlreadyOpen,
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+
+	for _, s := range sr.Storms {
+		entry := storm{
+			Kind:     s.Kind,
+			Start:    ms(s.Start),
+			End:      ms(s.End),
+			Count:    s.Count,
+			Baseline: s.Baseline,
+			Clients:  s.Clients,
+			Tags:     s.Tags,
+		}
+		if s.Latency.Count > 0 {
+			ts, err := NewTimeStatistics(s.Latency, sr.config.Percentiles)
+			if err != nil {
+				return nil, err
+			}
+			entry.Latency = &ts
+		}
+		data.Storms = append(data.Storms, entry)
+	}
+
+	if sr.Latency.Count > 0 {
+		ts, err := NewTimeStatistics(sr.Latency, sr.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+		data.Latency = &ts
+	}
+
+	return json.Marshal(data)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStormReport(t *testing.T) {
+	var frames Frames
+	stream := 0
+	connect := func(at time.Duration, client string, duration time.Duration) {
+		login := &Frame{TCPStream: stream, TimeRelative: at, SrcHost: client, SrcPort: 40000 + stream, DstHost: "10.0.0.1", DstPort: 3306, MySQLUser: "app"}
+		frame := &Frame{TCPStream: stream, TimeRelative: at, SrcHost: client, SrcPort: 40000 + stream, DstHost: "10.0.0.1", DstPort: 3306, MySQLCommand: 3, MySQLQuery: NewMySQLQuery("SELECT 1 /*controller:" + client + "*/")}
+		frame.MySQLQuery.Duration = duration
+		frames = append(frames, login, frame)
+		stream++
+	}
+
+	// one connection per second for ten seconds
+	for i := 0; i < 10; i++ {
+		connect(time.Duration(i)*time.Second, "10.0.0.2", time.Millisecond)
+	}
+	// a pool reconnecting all at once in the fifth second
+	for i := 0; i < 8; i++ {
+		connect(5*time.Second+time.Duration(i)*time.Millisecond, "10.0.0."+strconv.Itoa(3+i%2), 10*time.Millisecond)
+	}
+
+	report := NewStormReport(DefaultStatisticsConfig(), DefaultStormConfig(), frames, 3306)
+	assert.Len(t, report.Storms, 1)
+
+	storm := report.Storms[0]
+	assert.Equal(t, StormConnections, storm.Kind)
+	assert.Equal(t, 5*time.Second, storm.Start)
+	assert.Equal(t, 6*time.Second, storm.End)
+	assert.Equal(t, 9, storm.Count)
+	assert.Equal(t, 1.0, storm.Baseline)
+	assert.Equal(t, map[string]int{"10.0.0.2": 1, "10.0.0.3": 4, "10.0.0.4": 4}, storm.Clients)
+	assert.Equal(t, 4, storm.Tags["controller:10.0.0.3"])
+	assert.Equal(t, 9, storm.Latency.Count)
+	assert.Equal(t, 9, report.Latency.Count)
+	assert.Equal(t, 0, report.AlreadyOpen)
+}
+
+func TestStormReportAlreadyOpen(t *testing.T) {
+	// a pool already open when the capture started, seen by its first queries
+	var frames Frames
+	for i := 0; i < 8; i++ {
+		frames = append(frames, &Frame{TCPStream: i, TimeRelative: time.Duration(i) * time.Millisecond, MySQLCommand: 3, MySQLQuery: NewMySQLQuery("SELECT 1")})
+	}
+	frames = append(frames, &Frame{TCPStream: 8, TimeRelative: 3 * time.Second, MySQLUser: "app"})
+
+	report := NewStormReport(DefaultStatisticsConfig(), DefaultStormConfig(), frames, 3306)
+	assert.Empty(t, report.Storms)
+	assert.Equal(t, 8, report.AlreadyOpen)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// the ways a stream can be closed
+const (
+	ClosedByClientFin = "client_fin"
+	ClosedByServerFin = "server_fin"
+	// ClosedByFin is a FIN when the endpoints were not captured
+	ClosedByFin     = "fin"
+	ClosedByRST     = "rst"
+	ClosedByComQuit = "com_quit"
+	// StillOpen is a stream still open at the end of the capture
+	StillOpen = "open"
+)
+
+// Streams tracks the lifecycle of every TCP stream of a capture. Frames can be
+// added in any order.
+type Streams struct {
+	// serverPort tells the server apart from the client when a stream has no
+	// mysql commands to tell them apart with
+	serverPort int
+	streams    map[int]*Stream
+}
+
+type Stream struct {
+	ID    int
+	First time.Duration
+	Last  time.Duration
+	// Started is set once a SYN or login request was seen, so the stream was
+	// opened during the capture rather than already open when it started
+	Started bool
+	// Closed is set once a FIN, RST or COM_QUIT was seen, at ClosedAt
+	Closed   bool
+	ClosedAt time.Duration
+	// LastOpen is the last frame that did not close the stream
+	LastOpen time.Duration
+	// Client is the host:port of the client, if the endpoints were captured
+	Client string
+	// Queries are the queries executed on the stream, in no particular order
+	Queries []Interval
+
+	// the frame that closed the stream
+	closeKind string
+	closeSrc  string
+	closePort int
+}
+
+func NewStreams(serverPort int) Streams {
+	return Streams{serverPort: serverPort, streams: make(map[int]*Stream)}
+}
+
+func (s *Streams) AddFrame(frame *Frame) {
+	stream, ok := s.streams[frame.TCPStream]
+	if !ok {
+		stream = &Stream{ID: frame.TCPStream, First: frame.TimeRelative, Last: frame.TimeRelative}
+		s.streams[frame.TCPStream] = stream
+	}
+
+	if frame.TimeRelative < stream.First {
+		stream.First = frame.TimeRelative
+	}
+	if frame.TimeRelative > stream.Last {
+		stream.Last = frame.TimeRelative
+	}
+
+	if frame.TCPSyn || frame.MySQLUser != "" {
+		stream.Started = true
+	}
+
+	// mysql commands are sent by the client
+	if frame.MySQLCommand != 0 || frame.MySQLQuery.Fingerprint != "" {
+		if src := frame.Src(); src != "" {
+			stream.Client = src
+		}
+	}
+
+	if frame.MySQLQuery.Fingerprint != "" {
+		stream.Queries = append(stream.Queries, Interval{Start: frame.TimeRelative, End: frame.TimeRelative + frame.MySQLQuery.Duration})
+	}
+
+	// if the TCP connection was closed, reset, or mysql quit was received
+	// mark the stream as closed
+	if frame.TCPFin || frame.TCPReset || frame.MySQLCommand == 1 {
+		if !stream.Closed || frame.TimeRelative < stream.ClosedAt {
+			stream.ClosedAt = frame.TimeRelative
+			stream.closeSrc = frame.Src()
+			stream.closePort = frame.SrcPort
+
+			switch {
+			case frame.MySQLCommand == 1:
+				stream.closeKind = ClosedByComQuit
+			case frame.TCPReset:
+				stream.closeKind = ClosedByRST
+			default:
+				stream.closeKind = ClosedByFin
+			}
+		}
+		stream.Closed = true
+	} else if frame.TimeRelative > stream.LastOpen {
+		stream.LastOpen = frame.TimeRelative
+	}
+}
+
+// Streams returns the streams ordered by ID
+func (s *Streams) Streams() []*Stream {
+	result := make([]*Stream, 0, len(s.streams))
+	for _, stream := range s.streams {
+		result = append(result, stream)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
+// ClosedBy returns how the stream was closed
+func (s *Streams) ClosedBy(stream *Stream) string {
+	if !stream.Closed {
+		return StillOpen
+	}
+	if stream.closeKind != ClosedByFin {
+		return stream.closeKind
+	}
+
+	switch {
+	case stream.Client != "" && stream.closeSrc != "":
+		if stream.closeSrc == stream.Client {
+			return ClosedByClientFin
+		}
+		return ClosedByServerFin
+	case stream.closePort != 0 && s.serverPort != 0:
+		if stream.closePort == s.serverPort {
+			return ClosedByServerFin
+		}
+		return ClosedByClientFin
+	}
+	return ClosedByFin
+}
+
+// Interval returns how long the stream was open, until end for streams
+// still open at the end of the capture
+func (s *Stream) Interval(end time.Duration) Interval {
+	if s.Closed {
+		return Interval{Start: s.First, End: s.ClosedAt}
+	}
+	return Interval{Start: s.First, End: end}
+}
+
+// Busy returns how long queries were executing on the stream
+func (s *Stream) Busy() time.Duration {
+	var busy time.Duration
+	for _, query := range s.Queries {
+		busy += query.End - query.Start
+	}
+	return busy
+}
+
+// LongestIdle returns the longest time the stream was open without a query
+// executing, including before the first and after the last query
+func (s *Stream) LongestIdle(end time.Duration) time.Duration {
+	queries := make([]Interval, len(s.Queries))
+	copy(queries, s.Queries)
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Start < queries[j].Start
+	})
+
+	interval := s.Interval(end)
+	var longest time.Duration
+	idleSince := interval.Start
+	for _, query := range queries {
+		if gap := query.Start - idleSince; gap > longest {
+			longest = gap
+		}
+		if query.End > idleSince {
+			idleSince = query.End
+		}
+	}
+	if gap := interval.End - idleSince; gap > longest {
+		longest = gap
+	}
+
+	return longest
+}
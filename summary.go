@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SummaryVersion is the version of the summary file format
+const SummaryVersion = 1
+
+// SummaryInterval is the bucket size of the concurrency series in summaries
+const SummaryInterval = 100 * time.Millisecond
+
+// SummaryGroupBys are the groups included in every summary
+var SummaryGroupBys = []string{"fingerprint", "tag", "fingerprint,error"}
+
+// Summary is the serialized, mergeable result of an analysis. Summaries of
+// captures from many hosts or capture windows can be merged into one report
+// without re-processing the raw captures.
+type Summary struct {
+	Version int `json:"version"`
+	// Sources lists the captures the summary was built from
+	Sources       []string `json:"sources"`
+	RelativeError float64  `json:"relative_error"`
+	// FingerprintStrategy is how the transactions were normalized, empty for
+	// summaries written before it was configurable, which used the set strategy
+	FingerprintStrategy string `json:"fingerprint_strategy,omitempty"`
+
+	NormalizedTransactions []*normalizedTransactionSummary `json:"normalized_transactions"`
+	Groups                 []*groupBySummary               `json:"groups"`
+	// Concurrency is keyed by source, each series starting when its capture started
+	Concurrency map[string]*ConcurrencySeries `json:"concurrency"`
+	// Durations is how long the capture of each source was
+	Durations map[string]time.Duration `json:"durations,omitempty"`
+}
+
+type normalizedTransactionSummary struct {
+	Fingerprint          []string                 `json:"fingerprint"`
+	Example              []string                 `json:"example_query"`
+	Tags                 []string                 `json:"tags"`
+	QueryDurations       *Histogram               `json:"query_durations"`
+	TransactionDurations *Histogram               `json:"transaction_durations"`
+	WasteDurations       *Histogram               `json:"waste_durations"`
+	Sources              map[string]*SourceTotals `json:"sources"`
+}
+
+type groupBySummary struct {
+	Dimensions string   `json:"dimensions"`
+	Groups     []*Group `json:"groups"`
+}
+
+// SourceTotals is the share of a group contributed by one capture
+type SourceTotals struct {
+	Count int           `json:"count"`
+	Sum   time.Duration `json:"sum"`
+}
+
+// NewSummary summarizes the analysis of a single capture, named source
+func NewSummary(source string, config StatisticsConfig, fp *FrameParser, groupBys []string) (Summary, error) {
+	summary := Summary{
+		Version:             SummaryVersion,
+		Sources:             []string{source},
+		RelativeError:       config.RelativeError,
+		FingerprintStrategy: config.FingerprintStrategy,
+		Concurrency:         make(map[string]*ConcurrencySeries),
+		Durations:           make(map[string]time.Duration),
+	}
+
+	nts := NewNormalizedTransactions(config)
+	for _, t := range fp.Transactions.Transactions {
+		nts.Add(*t)
+	}
+	for _, nt := range nts.Transactions {
+		nt.sources = map[string]*SourceTotals{source: {Count: nt.transactionDurations.Count, Sum: nt.transactionDurations.Sum}}
+	}
+	summary.setNormalizedTransactions(&nts)
+
+	for _, spec := range groupBys {
+		dimensions, err := ParseDimensions(spec)
+		if err != nil {
+			return summary, err
+		}
+
+		gb := NewGroupBy(config, dimensions)
+		for _, frame := range fp.Frames {
+			gb.AddFrame(frame)
+		}
+		for _, group := range gb.groups {
+			group.Sources = map[string]*SourceTotals{source: {Count: group.Durations.Count, Sum: group.Durations.Sum}}
+		}
+		summary.setGroupBy(&gb)
+	}
+
+	timeline := NewTimeline(fp.Frames, fp.Transactions, io.Discard)
+	dbs := NewDurationBuckets(SummaryInterval)
+	series := dbs.Series(timeline, nil)
+	series.Start = fp.startTime
+	summary.Concurrency[source] = &series
+	summary.Durations[source] = timeline.End
+
+	return summary, nil
+}
+
+func ReadSummary(path string) (Summary, error) {
+	var summary Summary
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return summary, err
+	}
+	if err := json.Unmarshal(b, &summary); err != nil {
+		return summary, fmt.Errorf("%s: %w", path, err)
+	}
+	if summary.Version != SummaryVersion {
+		return summary, fmt.Errorf("%s: unsupported summary version %d", path, summary.Version)
+	}
+
+	return summary, nil
+}
+
+func (s *Summary) Write(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// Merge adds another summary into s
+func (s *Summary) Merge(other *Summary, config StatisticsConfig) error {
+	if s.Version == 0 {
+		s.Version = SummaryVersion
+		s.RelativeError = other.RelativeError
+		s.FingerprintStrategy = other.FingerprintStrategy
+		s.Concurrency = make(map[string]*ConcurrencySeries)
+	}
+	if s.RelativeError != other.RelativeError {
+		return fmt.Errorf("cannot merge summaries with relative error %v and %v", s.RelativeError, other.RelativeError)
+	}
+	if s.fingerprintStrategy() != other.fingerprintStrategy() {
+		return fmt.Errorf("cannot merge summaries with transaction fingerprint strategies %s and %s", s.fingerprintStrategy(), other.fingerprintStrategy())
+	}
+	config.RelativeError = s.RelativeError
+
+	// a source is listed once however many of its capture windows are merged
+	for _, source := range other.Sources {
+		if !containsString(s.Sources, source) {
+			s.Sources = append(s.Sources, source)
+		}
+	}
+
+	nts := s.NormalizedTransactionsWith(config)
+	otherNts := other.NormalizedTransactionsWith(config)
+	if err := nts.Merge(&otherNts); err != nil {
+		return err
+	}
+	s.setNormalizedTransactions(&nts)
+
+	for _, other := range other.Groups {
+		gb, err := s.GroupBy(other.Dimensions, config)
+		if err != nil {
+			return err
+		}
+		otherGb, err := groupByFromSummary(other, config)
+		if err != nil {
+			return err
+		}
+		if err := gb.Merge(&otherGb); err != nil {
+			return err
+		}
+		s.setGroupBy(&gb)
+	}
+
+	for source, series := range other.Concurrency {
+		if _, ok := s.Concurrency[source]; !ok {
+			s.Concurrency[source] = &ConcurrencySeries{}
+		}
+		if err := s.Concurrency[source].Add(series); err != nil {
+			return err
+		}
+	}
+
+	// the capture windows of one source follow each other, so their
+	// durations add up like their series are appended
+	if s.Durations == nil && len(other.Durations) > 0 {
+		s.Durations = make(map[string]time.Duration)
+	}
+	for source, d := range other.Durations {
+		s.Durations[source] += d
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Summary) fingerprintStrategy() string {
+	if s.FingerprintStrategy == "" {
+		return TransactionFingerprintSet
+	}
+	return s.FingerprintStrategy
+}
+
+// CaptureDuration returns the duration of the longest capture of the summary,
+// which rates are based on since the captures of a fleet run side by side.
+// It is zero for summaries written before durations were recorded.
+func (s *Summary) CaptureDuration() time.Duration {
+	var result time.Duration
+	for _, d := range s.Durations {
+		if d > result {
+			result = d
+		}
+	}
+	return result
+}
+
+// NormalizedTransactionsWith returns the normalized transactions of the summary,
+// reported with the percentiles of config
+func (s *Summary) NormalizedTransactionsWith(config StatisticsConfig) NormalizedTransactions {
+	config.RelativeError = s.RelativeError
+	nts := NewNormalizedTransactions(config)
+
+	for _, summary := range s.NormalizedTransactions {
+		nt := NewNormalizedTransaction(config)
+		nt.Fingerprint = summary.Fingerprint
+		nt.Example = summary.Example
+		for _, tag := range summary.Tags {
+			nt.tags[tag] = true
+		}
+		nt.queryDurations = summary.QueryDurations
+		nt.transactionDurations = summary.TransactionDurations
+		nt.wasteDurations = summary.WasteDurations
+		nt.sources = summary.Sources
+
+		nts.Transactions[strings.Join(nt.Fingerprint, "\n")+"\n"] = &nt
+	}
+
+	return nts
+}
+
+func (s *Summary) setNormalizedTransactions(nts *NormalizedTransactions) {
+	s.NormalizedTransactions = nil
+	for _, nt := range nts.Transactions {
+		summary := &normalizedTransactionSummary{
+			Fingerprint:          nt.Fingerprint,
+			Example:              nt.Example,
+			QueryDurations:       nt.queryDurations,
+			TransactionDurations: nt.transactionDurations,
+			WasteDurations:       nt.wasteDurations,
+			Sources:              nt.sources,
+		}
+		for tag := range nt.tags {
+			summary.Tags = append(summary.Tags, tag)
+		}
+		sort.Strings(summary.Tags)
+		s.NormalizedTransactions = append(s.NormalizedTransactions, summary)
+	}
+}
+
+// GroupBy returns the groups of the summary for the given dimensions,
+// empty if the summary does not have them
+func (s *Summary) GroupBy(spec string, config StatisticsConfig) (GroupBy, error) {
+	config.RelativeError = s.RelativeError
+	for _, summary := range s.Groups {
+		if summary.Dimensions == spec {
+			return groupByFromSummary(summary, config)
+		}
+	}
+
+	dimensions, err := ParseDimensions(spec)
+	if err != nil {
+		return GroupBy{}, err
+	}
+	return NewGroupBy(config, dimensions), nil
+}
+
+func groupByFromSummary(summary *groupBySummary, config StatisticsConfig) (GroupBy, error) {
+	dimensions, err := ParseDimensions(summary.Dimensions)
+	if err != nil {
+		return GroupBy{}, err
+	}
+
+	gb := NewGroupBy(config, dimensions)
+	for _, group := range summary.Groups {
+		gb.groups[strings.Join(group.Values, "\t")] = group
+	}
+	return gb, nil
+}
+
+func (s *Summary) setGroupBy(gb *GroupBy) {
+	summary := &groupBySummary{Dimensions: gb.Spec(), Groups: gb.Groups()}
+
+	for idx, existing := range s.Groups {
+		if existing.Dimensions == summary.Dimensions {
+			s.Groups[idx] = summary
+			return
+		}
+	}
+	s.Groups = append(s.Groups, summary)
+}
+
+// TotalConcurrency returns the concurrency series of all sources added together
+func (s *Summary) TotalConcurrency() (ConcurrencySeries, error) {
+	var total ConcurrencySeries
+	for _, source := range s.Sources {
+		if series, ok := s.Concurrency[source]; ok {
+			if err := total.Add(series); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// Report returns the merged results of the summary, broken down by source
+func (s *Summary) Report(config StatisticsConfig) ([]byte, error) {
+	type groupReport struct {
+		Values     []string        `json:"values"`
+		Statistics *TimeStatistics `json:"statistics"`
+		Sources    SourceReport    `json:"sources"`
+	}
+	type groupByReport struct {
+		Dimensions string         `json:"dimensions"`
+		Groups     []*groupReport `json:"groups"`
+	}
+
+	concurrency, err := s.TotalConcurrency()
+	if err != nil {
+		return nil, err
+	}
+
+	nts := s.NormalizedTransactionsWith(config)
+	data := struct {
+		Sources                []string                      `json:"sources"`
+		NormalizedTransactions *NormalizedTransactions       `json:"normalized_transactions"`
+		Groups                 []*groupByReport              `json:"groups"`
+		Concurrency            ConcurrencySeries             `json:"concurrency"`
+		SourceConcurrency      map[string]*ConcurrencySeries `json:"source_concurrency"`
+	}{
+		Sources:                s.Sources,
+		NormalizedTransactions: &nts,
+		Concurrency:            concurrency,
+		SourceConcurrency:      s.Concurrency,
+	}
+
+	for _, summary := range s.Groups {
+		report := &groupByReport{Dimensions: summary.Dimensions}
+		for _, group := range summary.Groups {
+			ts, err := NewTimeStatistics(group.Durations, config.Percentiles)
+			if err != nil {
+				return nil, err
+			}
+			report.Groups = append(report.Groups, &groupReport{
+				Values:     group.Values,
+				Statistics: &ts,
+				Sources:    NewSourceReport(group.Sources),
+			})
+		}
+		data.Groups = append(data.Groups, report)
+	}
+
+	return json.MarshalIndent(data, "", " ")
+}
+
+func mergeSourceTotals(a, b map[string]*SourceTotals) map[string]*SourceTotals {
+	if len(b) == 0 {
+		return a
+	}
+
+	result := make(map[string]*SourceTotals, len(a)+len(b))
+	for _, totals := range []map[string]*SourceTotals{a, b} {
+		for source, t := range totals {
+			if _, ok := result[source]; !ok {
+				result[source] = &SourceTotals{}
+			}
+			result[source].Count += t.Count
+			result[source].Sum += t.Sum
+		}
+	}
+	return result
+}
+
+// SourceReport is the per source breakdown of a report, durations in milliseconds
+type SourceReport map[string]struct {
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	Mean  float64 `json:"mean"`
+}
+
+func NewSourceReport(sources map[string]*SourceTotals) SourceReport {
+	if len(sources) == 0 {
+		return nil
+	}
+
+	result := make(SourceReport, len(sources))
+	for source, totals := range sources {
+		entry := result[source]
+		entry.Count = totals.Count
+		entry.Sum = float64(totals.Sum) / float64(time.Millisecond)
+		if totals.Count > 0 {
+			entry.Mean = entry.Sum / float64(totals.Count)
+		}
+		result[source] = entry
+	}
+	return result
+}
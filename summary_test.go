@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryMerge(t *testing.T) {
+	config := DefaultStatisticsConfig()
+	dir := t.TempDir()
+
+	var paths []string
+	for _, source := range []string{"db1", "db2"} {
+		fp := NewFrameParser()
+		fp.Frames, fp.Transactions = buildTransactions(t, 1, [][]timedQuery{{
+			{"BEGIN", 0},
+			{"SELECT 1 FROM foo /*controller:bar*/", 10 * time.Millisecond},
+			{"COMMIT", 20 * time.Millisecond},
+		}})
+
+		summary, err := NewSummary(source, config, &fp, SummaryGroupBys)
+		assert.NoError(t, err)
+
+		path := filepath.Join(dir, source+".json")
+		assert.NoError(t, summary.Write(path))
+		paths = append(paths, path)
+	}
+
+	var merged Summary
+	for _, path := range paths {
+		summary, err := ReadSummary(path)
+		assert.NoError(t, err)
+		assert.NoError(t, merged.Merge(&summary, config))
+	}
+
+	assert.Equal(t, []string{"db1", "db2"}, merged.Sources)
+
+	nts := merged.NormalizedTransactionsWith(config)
+	assert.Len(t, nts.Transactions, 1)
+	for _, nt := range nts.Transactions {
+		assert.Equal(t, 2, nt.transactionDurations.Count)
+		assert.Equal(t, 1, nt.sources["db1"].Count)
+		assert.Equal(t, 1, nt.sources["db2"].Count)
+	}
+
+	tags, err := merged.GroupBy("tag", config)
+	assert.NoError(t, err)
+	groups := tags.Groups()
+	assert.Len(t, groups, 1)
+	assert.Equal(t, []string{"controller:bar"}, groups[0].Values)
+	assert.Equal(t, 2, groups[0].Durations.Count)
+
+	concurrency, err := merged.TotalConcurrency()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, concurrency.Buckets[0].Connections.Max)
+}
+
+func TestSummaryMergeAlignsConcurrency(t *testing.T) {
+	config := DefaultStatisticsConfig()
+	start := time.Unix(1700000000, 0)
+
+	summarize := func(source string, offset time.Duration) Summary {
+		fp := NewFrameParser()
+		fp.startTime = start.Add(offset)
+		fp.Frames, _ = buildTransactions(t, 1, [][]timedQuery{{{"SELECT 1", 0}, {"SELECT 2", 50 * time.Millisecond}}})
+		summary, err := NewSummary(source, config, &fp, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, start.Add(offset), summary.Concurrency[source].Start)
+		return summary
+	}
+
+	var merged Summary
+	for _, summary := range []Summary{summarize("db1", 0), summarize("db2", time.Second), summarize("db1", 2*time.Second)} {
+		assert.NoError(t, merged.Merge(&summary, config))
+	}
+
+	// db2 started a second after db1, and the second window of db1 a second after that
+	concurrency, err := merged.TotalConcurrency()
+	assert.NoError(t, err)
+	assert.Equal(t, start, concurrency.Start)
+	assert.Len(t, concurrency.Buckets, 21)
+	assert.Equal(t, 1, concurrency.Buckets[0].Connections.Max)
+	assert.Equal(t, 0, concurrency.Buckets[5].Connections.Max)
+	assert.Equal(t, 1, concurrency.Buckets[10].Connections.Max)
+	assert.Equal(t, 1, concurrency.Buckets[20].Connections.Max)
+	assert.Len(t, merged.Concurrency["db1"].Buckets, 21)
+}
+
+func TestSummaryMergeFingerprintStrategy(t *testing.T) {
+	config := DefaultStatisticsConfig()
+	merged := Summary{Version: SummaryVersion, RelativeError: config.RelativeError}
+	other := Summary{Version: SummaryVersion, RelativeError: config.RelativeError, FingerprintStrategy: TransactionFingerprintSet}
+	assert.NoError(t, merged.Merge(&other, config))
+
+	other.FingerprintStrategy = TransactionFingerprintCollapsed
+	assert.Error(t, merged.Merge(&other, config))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// TableStats is the workload of one table
+type TableStats struct {
+	Name string
+	// Reads and Writes count the queries reading and writing the table
+	Reads  int
+	Writes int
+	// Statements counts the queries by statement type
+	Statements map[string]int
+	Durations  *Histogram
+	Tags       map[string]int
+	// Transactions counts the transactions touching the table by normalized transaction id
+	Transactions map[string]int
+}
+
+// TablesReport is the workload of a capture by table
+type TablesReport struct {
+	config     StatisticsConfig
+	tables     map[string]*TableStats
+	statements StatementCache
+}
+
+// NewTablesReport attributes every query to the tables it reads and writes
+func NewTablesReport(config StatisticsConfig, frames Frames, transactions Transactions) TablesReport {
+	report := TablesReport{
+		config:     config,
+		tables:     make(map[string]*TableStats),
+		statements: make(StatementCache),
+	}
+
+	for _, frame := range frames {
+		statement := report.Statement(frame)
+		for _, name := range statement.Tables() {
+			table := report.table(name)
+			table.Statements[statement.Type]++
+			table.Durations.Add(frame.MySQLQuery.Duration)
+			for k, v := range frame.MySQLQuery.Tags {
+				table.Tags[k+":"+v]++
+			}
+		}
+		for _, name := range statement.Reads {
+			report.tables[name].Reads++
+		}
+		for _, name := range statement.Writes {
+			report.tables[name].Writes++
+		}
+	}
+
+	for _, t := range transactions.Transactions {
+		id := TransactionID(t.FingerprintWith(config.FingerprintStrategy))
+		touched := make(map[string]bool)
+		for _, frame := range t.Frames {
+			statement := report.Statement(frame)
+			for _, name := range statement.Tables() {
+				touched[name] = true
+			}
+		}
+		for name := range touched {
+			report.table(name).Transactions[id]++
+		}
+	}
+
+	return report
+}
+
+// Statement returns the parsed statement of the frame
+func (r *TablesReport) Statement(frame *Frame) Statement {
+	return r.statements.Parse(frame.MySQLQuery.Fingerprint)
+}
+
+func (r *TablesReport) table(name string) *TableStats {
+	if _, ok := r.tables[name]; !ok {
+		r.tables[name] = &TableStats{
+			Name:         name,
+			Statements:   make(map[string]int),
+			Durations:    NewHistogram(r.config.RelativeError),
+			Tags:         make(map[string]int),
+			Transactions: make(map[string]int),
+		}
+	}
+	return r.tables[name]
+}
+
+// Tables returns the tables ordered by total query time, most first
+func (r *TablesReport) Tables() []*TableStats {
+	result := make([]*TableStats, 0, len(r.tables))
+	for _, table := range r.tables {
+		result = append(result, table)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Durations.Sum != result[j].Durations.Sum {
+			return result[i].Durations.Sum > result[j].Durations.Sum
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (r *TablesReport) MarshalJSON() ([]byte, error) {
+	type table struct {
+		Name         string         `json:"table"`
+		Reads        int            `json:"reads"`
+		Writes       int            `json:"writes"`
+		Statements   map[string]int `json:"statements"`
+		Duration     TimeStatistics `json:"duration"`
+		Tags         map[string]int `json:"tags"`
+		Transactions map[string]int `json:"transactions"`
+	}
+
+	result := []table{}
+	for _, t := range r.Tables() {
+		ts, err := NewTimeStatistics(t.Durations, r.config.Percentiles)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, table{
+			Name:         t.Name,
+			Reads:        t.Reads,
+			Writes:       t.Writes,
+			Statements:   t.Statements,
+			Duration:     ts,
+			Tags:         t.Tags,
+			Transactions: t.Transactions,
+		})
+	}
+
+	return json.Marshal(result)
+}
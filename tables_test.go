@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTablesReport(t *testing.T) {
+	frames, transactions := buildTransactions(t, 1, [][]timedQuery{{
+		{"BEGIN", 0},
+		{"SELECT * FROM users WHERE id = 1 /*controller:users*/", time.Millisecond},
+		{"UPDATE users SET name = 'a' WHERE id = 1 /*controller:users*/", 2 * time.Millisecond},
+		{"INSERT INTO audit SELECT * FROM log /*controller:users*/", 3 * time.Millisecond},
+		{"COMMIT", 4 * time.Millisecond},
+	}})
+	transaction := transactions.Transactions[0]
+
+	report := NewTablesReport(DefaultStatisticsConfig(), frames, transactions)
+	tables := report.Tables()
+	assert.Len(t, tables, 3)
+
+	users := tables[0]
+	assert.Equal(t, "users", users.Name)
+	assert.Equal(t, 1, users.Reads)
+	assert.Equal(t, 1, users.Writes)
+	assert.Equal(t, map[string]int{StatementSelect: 1, StatementUpdate: 1}, users.Statements)
+	assert.Equal(t, 2*time.Millisecond, users.Durations.Sum)
+	assert.Equal(t, 2, users.Tags["controller:users"])
+	assert.Equal(t, map[string]int{TransactionID(transaction.FingerprintSlice(true)): 1}, users.Transactions)
+
+	assert.Equal(t, "audit", tables[1].Name)
+	assert.Equal(t, 1, tables[1].Writes)
+	assert.Equal(t, "log", tables[2].Name)
+	assert.Equal(t, 1, tables[2].Reads)
+}
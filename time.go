@@ -1,83 +1,123 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
-
-	"github.com/montanaflynn/stats"
 )
 
-type TimeStatistics struct {
-	Min   time.Duration
-	Mean  time.Duration
-	P95   time.Duration
-	P99   time.Duration
-	Max   time.Duration
-	Sum   time.Duration
-	Count int
-}
+// DefaultPercentiles are the percentiles reported unless configured otherwise
+var DefaultPercentiles = []float64{50, 95, 99}
 
-func NewTimeStatistics(durations []time.Duration) (TimeStatistics, error) {
-	var ts TimeStatistics
-	data := stats.LoadRawData(durations)
-
-	ts.Count = len(durations)
+// StatisticsConfig controls the accuracy and the percentiles of reported statistics
+type StatisticsConfig struct {
+	// RelativeError is the relative error of the histograms
+	RelativeError float64
+	// Percentiles is the list of percentiles reported, between 0 and 100
+	Percentiles []float64
+	// FingerprintStrategy is how transactions are normalized, one of the
+	// TransactionFingerprint strategies
+	FingerprintStrategy string
+}
 
-	min, err := data.Min()
-	if err != nil {
-		return ts, err
+func DefaultStatisticsConfig() StatisticsConfig {
+	return StatisticsConfig{
+		RelativeError:       DefaultRelativeError,
+		Percentiles:         DefaultPercentiles,
+		FingerprintStrategy: TransactionFingerprintSet,
 	}
-	ts.Min = time.Duration(min)
+}
 
-	mean, err := data.Mean()
-	if err != nil {
-		return ts, err
+// ParsePercentiles parses a comma separated list of percentiles, e.g. "50,90,99.9"
+func ParsePercentiles(spec string) ([]float64, error) {
+	var result []float64
+	for _, s := range strings.Split(spec, ",") {
+		p, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(s), "p"), 64)
+		if err != nil {
+			return nil, err
+		}
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("percentile %v is out of bounds", p)
+		}
+		result = append(result, p)
 	}
-	ts.Mean = time.Duration(mean)
+	return result, nil
+}
 
-	p95, err := data.Percentile(95)
-	if err != nil {
-		return ts, err
-	}
-	ts.P95 = time.Duration(p95)
+type Percentile struct {
+	Percentile float64
+	Value      time.Duration
+}
 
-	p99, err := data.Percentile(99)
-	if err != nil {
-		return ts, err
-	}
-	ts.P99 = time.Duration(p99)
+// Name returns the name of the percentile, e.g. p99.9
+func (p Percentile) Name() string {
+	return "p" + strconv.FormatFloat(p.Percentile, 'f', -1, 64)
+}
 
-	max, err := data.Max()
-	if err != nil {
-		return ts, err
+type TimeStatistics struct {
+	Min         time.Duration
+	Mean        time.Duration
+	Percentiles []Percentile
+	Max         time.Duration
+	Sum         time.Duration
+	Count       int
+}
+
+func NewTimeStatistics(h *Histogram, percentiles []float64) (TimeStatistics, error) {
+	var ts TimeStatistics
+
+	if h.Count == 0 {
+		return ts, errors.New("no durations to compute statistics from")
 	}
-	ts.Max = time.Duration(max)
 
-	sum, err := data.Sum()
-	if err != nil {
-		return ts, err
+	ts.Count = h.Count
+	ts.Min = h.Min
+	ts.Mean = h.Mean()
+	ts.Max = h.Max
+	ts.Sum = h.Sum
+
+	for _, p := range percentiles {
+		ts.Percentiles = append(ts.Percentiles, Percentile{Percentile: p, Value: h.Quantile(p / 100)})
 	}
-	ts.Sum = time.Duration(sum)
 
 	return ts, nil
 }
 
+// Percentile returns the value of the given percentile, if it was computed
+func (ts *TimeStatistics) Percentile(p float64) (time.Duration, bool) {
+	for _, percentile := range ts.Percentiles {
+		if percentile.Percentile == p {
+			return percentile.Value, true
+		}
+	}
+	return 0, false
+}
+
 func (nt *TimeStatistics) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Min   float64 `json:"min"`
-		Mean  float64 `json:"mean"`
-		P95   float64 `json:"p95"`
-		P99   float64 `json:"p99"`
-		Max   float64 `json:"max"`
-		Sum   float64 `json:"sum"`
-		Count int     `json:"count"`
-	}{
-		Min:   float64(nt.Min) / float64(time.Millisecond),
-		Mean:  float64(nt.Mean) / float64(time.Millisecond),
-		P95:   float64(nt.P95) / float64(time.Millisecond),
-		P99:   float64(nt.P99) / float64(time.Millisecond),
-		Max:   float64(nt.Max) / float64(time.Millisecond),
-		Sum:   float64(nt.Sum) / float64(time.Millisecond),
-		Count: nt.Count,
-	})
+	// written by hand to keep the configured percentiles in order
+	var buf bytes.Buffer
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	}
+
+	buf.WriteString(`{"min":` + ms(nt.Min))
+	buf.WriteString(`,"mean":` + ms(nt.Mean))
+	for _, p := range nt.Percentiles {
+		name, err := json.Marshal(p.Name())
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(",")
+		buf.Write(name)
+		buf.WriteString(":" + ms(p.Value))
+	}
+	buf.WriteString(`,"max":` + ms(nt.Max))
+	buf.WriteString(`,"sum":` + ms(nt.Sum))
+	buf.WriteString(`,"count":` + strconv.Itoa(nt.Count) + "}")
+
+	return buf.Bytes(), nil
 }
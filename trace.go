@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TraceEvent is one event of the Chrome Trace Event format, as read by
+// chrome://tracing and Perfetto. Timestamps and durations are in microseconds.
+type TraceEvent struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat,omitempty"`
+	Phase    string            `json:"ph"`
+	Time     float64           `json:"ts"`
+	Duration float64           `json:"dur,omitempty"`
+	Process  int               `json:"pid"`
+	Thread   int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+// Trace is the capture as Chrome trace events: one track per TCP stream, with
+// transactions as slices enclosing their queries
+type Trace struct {
+	Events []TraceEvent `json:"traceEvents"`
+	Unit   string       `json:"displayTimeUnit"`
+}
+
+func NewTrace(frames Frames, transactions Transactions) Trace {
+	trace := Trace{Unit: "ms"}
+
+	us := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Microsecond)
+	}
+
+	streams := make(map[int]bool)
+	for _, frame := range frames {
+		if !streams[frame.TCPStream] {
+			streams[frame.TCPStream] = true
+			trace.Events = append(trace.Events, TraceEvent{
+				Name:    "thread_name",
+				Phase:   "M",
+				Process: 1,
+				Thread:  frame.TCPStream,
+				Args:    map[string]string{"name": "stream " + strconv.Itoa(frame.TCPStream)},
+			})
+		}
+
+		if frame.MySQLQuery.Fingerprint == "" {
+			continue
+		}
+
+		args := map[string]string{"query": frame.MySQLQuery.Query, "frame": strconv.Itoa(frame.Number)}
+		for k, v := range frame.MySQLQuery.Tags {
+			args[k] = v
+		}
+
+		trace.Events = append(trace.Events, TraceEvent{
+			Name:     frame.MySQLQuery.Fingerprint,
+			Category: "query",
+			Phase:    "X",
+			Time:     us(frame.TimeRelative),
+			Duration: us(frame.MySQLQuery.Duration),
+			Process:  1,
+			Thread:   frame.TCPStream,
+			Args:     args,
+		})
+	}
+
+	for _, t := range transactions.Transactions {
+		trace.Events = append(trace.Events, TraceEvent{
+			Name:     "transaction",
+			Category: "transaction",
+			Phase:    "X",
+			Time:     us(t.Frames[0].TimeRelative),
+			Duration: us(t.TotalDuration()),
+			Process:  1,
+			Thread:   t.Frames[0].TCPStream,
+			Args: map[string]string{
+				"queries": strconv.Itoa(len(t.Frames)),
+				"waste":   t.WasteDuration().String(),
+			},
+		})
+	}
+
+	// viewers nest slices that start at the same time by order, so enclosing
+	// transactions go before their queries
+	sort.SliceStable(trace.Events, func(i, j int) bool {
+		a, b := trace.Events[i], trace.Events[j]
+		if (a.Phase == "M") != (b.Phase == "M") {
+			return a.Phase == "M"
+		}
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		if a.Duration != b.Duration {
+			return a.Duration > b.Duration
+		}
+		return a.Thread < b.Thread
+	})
+
+	return trace
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrace(t *testing.T) {
+	frames, transactions := buildTransactions(t, 3, [][]timedQuery{{
+		{"BEGIN", 0},
+		{"SELECT 1 FROM foo /*controller:bar*/", 10 * time.Millisecond},
+		{"COMMIT", 20 * time.Millisecond},
+	}})
+
+	trace := NewTrace(frames, transactions)
+	assert.Len(t, trace.Events, 5)
+
+	assert.Equal(t, "M", trace.Events[0].Phase)
+	assert.Equal(t, "stream 3", trace.Events[0].Args["name"])
+
+	// the transaction encloses its queries
+	assert.Equal(t, "transaction", trace.Events[1].Name)
+	assert.Equal(t, 0.0, trace.Events[1].Time)
+	assert.Equal(t, 21000.0, trace.Events[1].Duration)
+	assert.Equal(t, "begin", trace.Events[2].Name)
+
+	query := trace.Events[3]
+	assert.Equal(t, "select ? from foo", query.Name)
+	assert.Equal(t, 10000.0, query.Time)
+	assert.Equal(t, 1000.0, query.Duration)
+	assert.Equal(t, 3, query.Thread)
+	assert.Equal(t, "bar", query.Args["controller"])
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
@@ -115,3 +117,147 @@ func (t *Transaction) FingerprintSlice(deduplicate bool) []string {
 
 	return result
 }
+
+// the strategies transactions are normalized with
+const (
+	// TransactionFingerprintExact keeps every statement in order
+	TransactionFingerprintExact = "exact"
+	// TransactionFingerprintSet keeps the first occurrence of every statement
+	TransactionFingerprintSet = "set"
+	// TransactionFingerprintCollapsed keeps the order but collapses repeated
+	// runs of statements into one loop, e.g. "(select ...)×N"
+	TransactionFingerprintCollapsed = "collapsed"
+)
+
+// ParseFingerprintStrategy validates a transaction fingerprint strategy
+func ParseFingerprintStrategy(strategy string) (string, error) {
+	switch strategy {
+	case TransactionFingerprintExact, TransactionFingerprintSet, TransactionFingerprintCollapsed:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown transaction fingerprint strategy %q", strategy)
+}
+
+// FingerprintWith returns the fingerprint of the transaction with the given
+// strategy, where an empty strategy is the set strategy of Fingerprint
+func (t *Transaction) FingerprintWith(strategy string) []string {
+	switch strategy {
+	case TransactionFingerprintExact:
+		return t.FingerprintSlice(false)
+	case TransactionFingerprintCollapsed:
+		return collapseRuns(t.FingerprintSlice(false))
+	default:
+		return t.FingerprintSlice(true)
+	}
+}
+
+// collapseRuns replaces consecutive repetitions of a block of statements with
+// the block written once, shortest blocks first so loops nest, until nothing
+// repeats. Loops of any length collapse into the same fingerprint.
+func collapseRuns(fingerprints []string) []string {
+	for period := 1; 2*period <= len(fingerprints); period++ {
+		var result []string
+		for idx := 0; idx < len(fingerprints); {
+			repeats := 1
+			for idx+(repeats+1)*period <= len(fingerprints) && equalStrings(fingerprints[idx:idx+period], fingerprints[idx+repeats*period:idx+(repeats+1)*period]) {
+				repeats++
+			}
+
+			if repeats == 1 {
+				result = append(result, fingerprints[idx])
+				idx++
+				continue
+			}
+			result = append(result, "("+strings.Join(fingerprints[idx:idx+period], "; ")+")×N")
+			idx += period * repeats
+		}
+
+		if len(result) < len(fingerprints) {
+			// start over with the shortest blocks around the collapsed loops
+			fingerprints = result
+			period = 0
+		}
+	}
+	return fingerprints
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// TransactionID returns a short stable id of a normalized transaction
+// fingerprint, to track the same transaction shape across captures
+func TransactionID(fingerprint []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fingerprint, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// the kinds of idle gaps within a transaction
+const (
+	GapAfterBegin   = "after_begin"
+	GapBetween      = "between"
+	GapBeforeCommit = "before_commit"
+	// GapEmpty is the gap of a transaction with no statement between BEGIN and COMMIT
+	GapEmpty = "empty"
+)
+
+// Gap is the time between the response to one statement of a transaction and
+// the next statement, when the transaction holds its locks doing nothing
+type Gap struct {
+	Kind     string
+	Before   *Frame
+	After    *Frame
+	Duration time.Duration
+}
+
+// Tags returns the tags of the statements around the gap
+func (g *Gap) Tags() map[string]string {
+	tags := make(map[string]string)
+	for _, frame := range []*Frame{g.Before, g.After} {
+		for k, v := range frame.MySQLQuery.Tags {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// Gaps breaks WasteDuration down into the gaps between consecutive statements
+func (t *Transaction) Gaps() []Gap {
+	var result []Gap
+	for idx := 1; idx < len(t.Frames); idx++ {
+		before, after := t.Frames[idx-1], t.Frames[idx]
+
+		gap := Gap{
+			Kind:     GapBetween,
+			Before:   before,
+			After:    after,
+			Duration: after.TimeRelative - before.TimeRelative - before.MySQLQuery.Duration,
+		}
+		// pipelined statements are sent before the previous response
+		if gap.Duration < 0 {
+			gap.Duration = 0
+		}
+
+		begins := idx == 1 && before.MySQLQuery.Fingerprint == "begin"
+		ends := idx == len(t.Frames)-1 && (after.MySQLQuery.Fingerprint == "commit" || after.MySQLQuery.Fingerprint == "rollback")
+		switch {
+		case begins && ends:
+			gap.Kind = GapEmpty
+		case begins:
+			gap.Kind = GapAfterBegin
+		case ends:
+			gap.Kind = GapBeforeCommit
+		}
+
+		result = append(result, gap)
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// timedQuery is a query of a test capture and when it was sent
+type timedQuery struct {
+	query string
+	at    time.Duration
+}
+
+// buildTransactions builds a transaction from each list of queries, with the
+// index of the list as its id. The transactions are on consecutive streams
+// from firstStream. Every query takes a millisecond and the frames are
+// numbered from 1 in order.
+func buildTransactions(t *testing.T, firstStream int, transactions [][]timedQuery) (Frames, Transactions) {
+	t.Helper()
+
+	var frames Frames
+	result := NewTransactions()
+	for id, queries := range transactions {
+		transaction := NewTransaction(id)
+		for _, q := range queries {
+			frame := &Frame{Number: len(frames) + 1, TCPStream: firstStream + id, TimeRelative: q.at, MySQLQuery: NewMySQLQuery(q.query)}
+			frame.MySQLQuery.Duration = time.Millisecond
+			frames = append(frames, frame)
+			transaction.AddFrame(frame)
+		}
+		result.Add(&transaction)
+	}
+	return frames, result
+}
+
+func TestFingerprintWith(t *testing.T) {
+	transaction := NewTransaction(0)
+	for _, query := range []string{
+		"BEGIN",
+		"SELECT * FROM users WHERE id = 1",
+		"UPDATE users SET seen = 1 WHERE id = 1",
+		"SELECT * FROM users WHERE id = 2",
+		"UPDATE users SET seen = 1 WHERE id = 2",
+		"INSERT INTO log VALUES (1)",
+		"COMMIT",
+	} {
+		transaction.AddFrame(&Frame{MySQLQuery: NewMySQLQuery(query)})
+	}
+
+	assert.Len(t, transaction.FingerprintWith(TransactionFingerprintExact), 7)
+	assert.Equal(t, transaction.FingerprintSlice(true), transaction.FingerprintWith(TransactionFingerprintSet))
+	assert.Equal(t, []string{
+		"begin",
+		"(select * from users where id = ?; update users set seen = ? where id = ?)×N",
+		"insert into log values(?+)",
+		"commit",
+	}, transaction.FingerprintWith(TransactionFingerprintCollapsed))
+
+	assert.Equal(t, []string{"a", "(b)×N", "c"}, collapseRuns([]string{"a", "b", "b", "b", "c"}))
+	assert.Equal(t, []string{"(a; (b)×N)×N"}, collapseRuns([]string{"a", "b", "b", "a", "b", "b", "b"}))
+	assert.Equal(t, []string{"a", "b", "a"}, collapseRuns([]string{"a", "b", "a"}))
+
+	nts := NewNormalizedTransactions(StatisticsConfig{RelativeError: DefaultRelativeError, FingerprintStrategy: TransactionFingerprintCollapsed})
+	nts.Add(transaction)
+	assert.Len(t, nts.Transactions, 1)
+	for _, nt := range nts.Transactions {
+		assert.Equal(t, TransactionID(nt.Fingerprint), nt.ID())
+		assert.Len(t, nt.ID(), 16)
+	}
+
+	_, err := ParseFingerprintStrategy("nope")
+	assert.Error(t, err)
+}
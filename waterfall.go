@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Waterfall lays the statements of one transaction out against its timeline
+type Waterfall struct {
+	Transaction *Transaction
+	Steps       []WaterfallStep
+	Total       time.Duration
+}
+
+// WaterfallStep is one statement of the transaction
+type WaterfallStep struct {
+	Frame *Frame
+	// Offset is when the statement was sent, from the start of the transaction
+	Offset time.Duration
+	// Server is how long the server took to respond
+	Server time.Duration
+	// Gap is the idle time until the next statement
+	Gap time.Duration
+}
+
+func NewWaterfall(t *Transaction) Waterfall {
+	waterfall := Waterfall{Transaction: t, Total: t.TotalDuration()}
+
+	gaps := t.Gaps()
+	start := t.Frames[0].TimeRelative
+	for idx, frame := range t.Frames {
+		step := WaterfallStep{
+			Frame:  frame,
+			Offset: frame.TimeRelative - start,
+			Server: frame.MySQLQuery.Duration,
+		}
+		if idx < len(gaps) {
+			step.Gap = gaps[idx].Duration
+		}
+		waterfall.Steps = append(waterfall.Steps, step)
+	}
+
+	return waterfall
+}
+
+// SelectTransaction picks the transaction containing the frame with the given
+// number, or else the slowest transaction on stream, or else the slowest
+// transaction with the given statement fingerprints separated by ";", or else
+// the slowest transaction whose normalized transaction has the given id with
+// the strategy. Negative frame and stream and an empty fingerprint and id are
+// ignored. Frame numbers restart in every capture file, so a frame number
+// matching several transactions is an error unless stream picks one of them.
+func SelectTransaction(transactions Transactions, frame int, stream int, fingerprint string, id string, strategy string) (*Transaction, error) {
+	if frame >= 0 {
+		return selectTransactionByFrame(transactions, frame, stream)
+	}
+
+	var fingerprints []string
+	if fingerprint != "" {
+		for _, f := range strings.Split(fingerprint, ";") {
+			fingerprints = append(fingerprints, strings.TrimSpace(f))
+		}
+	}
+
+	var result *Transaction
+	for _, t := range transactions.Transactions {
+		switch {
+		case stream >= 0:
+			if t.Frames[0].TCPStream != stream {
+				continue
+			}
+		case fingerprints != nil:
+			if strings.Join(t.FingerprintSlice(true), "\n") != strings.Join(fingerprints, "\n") {
+				continue
+			}
+		case id != "":
+			if TransactionID(t.FingerprintWith(strategy)) != id {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("one of --frame, --stream, --fingerprint or --id is required")
+		}
+
+		// ties go to the earliest transaction so the choice is stable
+		if result == nil || t.TotalDuration() > result.TotalDuration() ||
+			(t.TotalDuration() == result.TotalDuration() && t.Frames[0].TimeRelative < result.Frames[0].TimeRelative) {
+			result = t
+		}
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("no matching transaction")
+	}
+	return result, nil
+}
+
+func selectTransactionByFrame(transactions Transactions, frame int, stream int) (*Transaction, error) {
+	var matches []*Transaction
+	for _, t := range transactions.Transactions {
+		if stream >= 0 && t.Frames[0].TCPStream != stream {
+			continue
+		}
+		for _, f := range t.Frames {
+			if f.Number == frame {
+				matches = append(matches, t)
+				break
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no matching transaction")
+	case 1:
+		return matches[0], nil
+	}
+
+	streams := make([]int, len(matches))
+	for idx, t := range matches {
+		streams[idx] = t.Frames[0].TCPStream
+	}
+	sort.Ints(streams)
+	return nil, fmt.Errorf("frame %d is in %d transactions, on streams %s, as frame numbers restart in every capture file: add --stream to pick one",
+		frame, len(matches), strings.Trim(fmt.Sprint(streams), "[]"))
+}
+
+// label returns the fingerprint of the statement, or the query if it has none
+func (s *WaterfallStep) label() string {
+	if s.Frame.MySQLQuery.Fingerprint != "" {
+		return s.Frame.MySQLQuery.Fingerprint
+	}
+	return s.Frame.MySQLQuery.Query
+}
+
+// ASCII renders the waterfall with bars width characters wide, '=' for the
+// server time of each statement and '.' for the gap after it
+func (w *Waterfall) ASCII(width int) string {
+	var builder strings.Builder
+
+	first := w.Steps[0].Frame
+	fmt.Fprintf(&builder, "stream %d, frame %d: total %s, queries %s, waste %s (%d%%)\n\n",
+		first.TCPStream, first.Number, w.Total, w.Transaction.QueryDuration(), w.Transaction.WasteDuration(), w.Transaction.WastePercentage())
+	fmt.Fprintf(&builder, "%12s %12s %12s  %-*s  %s\n", "offset", "server", "gap", width+2, "", "statement")
+
+	column := func(d time.Duration) int {
+		if w.Total <= 0 {
+			return 0
+		}
+		c := int(int64(d) * int64(width) / int64(w.Total))
+		if c > width {
+			c = width
+		}
+		return c
+	}
+
+	for _, step := range w.Steps {
+		bar := []byte(strings.Repeat(" ", width))
+
+		start := column(step.Offset)
+		end := column(step.Offset + step.Server)
+		// every statement gets at least one character
+		if end <= start && start < width {
+			end = start + 1
+		}
+		gapEnd := column(step.Offset + step.Server + step.Gap)
+
+		for i := start; i < end && i < width; i++ {
+			bar[i] = '='
+		}
+		for i := end; i < gapEnd; i++ {
+			bar[i] = '.'
+		}
+
+		fmt.Fprintf(&builder, "%12s %12s %12s  |%s|  %s\n", step.Offset, step.Server, step.Gap, bar, step.label())
+	}
+
+	return builder.String()
+}
+
+// SVG renders the waterfall as a standalone SVG document
+func (w *Waterfall) SVG() string {
+	const (
+		chartWidth = 800
+		rowHeight  = 20
+		margin     = 10
+		header     = 40
+	)
+
+	escape := func(s string) string {
+		var builder strings.Builder
+		xml.EscapeText(&builder, []byte(s))
+		return builder.String()
+	}
+
+	x := func(d time.Duration) float64 {
+		if w.Total <= 0 {
+			return margin
+		}
+		return margin + float64(d)/float64(w.Total)*chartWidth
+	}
+
+	height := header + len(w.Steps)*rowHeight + 2*margin
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`+"\n", 2*chartWidth, height)
+
+	first := w.Steps[0].Frame
+	fmt.Fprintf(&builder, `<text x="%d" y="%d">stream %d, frame %d: total %s, queries %s, waste %s (%d%%)</text>`+"\n",
+		margin, margin+12, first.TCPStream, first.Number, escape(w.Total.String()), escape(w.Transaction.QueryDuration().String()),
+		escape(w.Transaction.WasteDuration().String()), w.Transaction.WastePercentage())
+	fmt.Fprintf(&builder, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`+"\n", margin, header-5, margin+chartWidth, header-5)
+
+	for idx, step := range w.Steps {
+		y := header + idx*rowHeight
+
+		serverWidth := x(step.Offset+step.Server) - x(step.Offset)
+		if serverWidth < 1 {
+			serverWidth = 1
+		}
+
+		fmt.Fprintf(&builder, `<rect x="%.2f" y="%d" width="%.2f" height="%d" fill="#4a90d9"><title>%s server %s</title></rect>`+"\n",
+			x(step.Offset), y+3, serverWidth, rowHeight-6, escape(step.label()), escape(step.Server.String()))
+		if step.Gap > 0 {
+			fmt.Fprintf(&builder, `<rect x="%.2f" y="%d" width="%.2f" height="%d" fill="#e0e0e0"><title>idle %s</title></rect>`+"\n",
+				x(step.Offset+step.Server), y+3, x(step.Offset+step.Server+step.Gap)-x(step.Offset+step.Server), rowHeight-6, escape(step.Gap.String()))
+		}
+		fmt.Fprintf(&builder, `<text x="%d" y="%d">%s +%s %s</text>`+"\n",
+			2*margin+chartWidth, y+rowHeight-6, escape(step.label()), escape(step.Offset.String()), escape(step.Server.String()))
+	}
+
+	builder.WriteString("</svg>\n")
+	return builder.String()
+}
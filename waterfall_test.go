@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaterfall(t *testing.T) {
+	totals := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	var queries [][]timedQuery
+	for _, total := range totals {
+		queries = append(queries, []timedQuery{{"BEGIN", 0}, {"SELECT 1 FROM foo", total / 4}, {"COMMIT", total / 2}})
+	}
+	_, transactions := buildTransactions(t, 1, queries)
+	for id, total := range totals {
+		for _, frame := range transactions.Transactions[id].Frames {
+			frame.MySQLQuery.Duration = total / 4
+		}
+	}
+
+	selected, err := SelectTransaction(transactions, 5, -1, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, selected.Frames[0].TCPStream)
+
+	selected, err = SelectTransaction(transactions, -1, 1, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, selected.Frames[0].TCPStream)
+
+	selected, err = SelectTransaction(transactions, -1, -1, "begin; select ? from foo; commit", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, selected.Frames[0].TCPStream)
+
+	id := TransactionID([]string{"begin", "select ? from foo", "commit"})
+	selected, err = SelectTransaction(transactions, -1, -1, "", id, TransactionFingerprintExact)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, selected.Frames[0].TCPStream)
+
+	_, err = SelectTransaction(transactions, -1, -1, "", "", "")
+	assert.Error(t, err)
+
+	// a rotated capture file numbered its frames from 1 again
+	rotated := NewTransaction(2)
+	rotated.AddFrame(&Frame{Number: 5, TCPStream: 3, TimeRelative: time.Second, MySQLQuery: NewMySQLQuery("BEGIN")})
+	transactions.Add(&rotated)
+	_, err = SelectTransaction(transactions, 5, -1, "", "", "")
+	assert.EqualError(t, err, "frame 5 is in 2 transactions, on streams 2 3, as frame numbers restart in every capture file: add --stream to pick one")
+	selected, err = SelectTransaction(transactions, 5, 3, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &rotated, selected)
+	selected, err = SelectTransaction(transactions, 5, 2, "", "", "")
+	assert.NoError(t, err)
+
+	waterfall := NewWaterfall(selected)
+	assert.Equal(t, 150*time.Millisecond, waterfall.Total)
+	assert.Equal(t, 50*time.Millisecond, waterfall.Steps[1].Offset)
+	assert.Equal(t, time.Duration(0), waterfall.Steps[1].Gap)
+
+	lines := strings.Split(waterfall.ASCII(6), "\n")
+	assert.Contains(t, lines[3], "|==    |  begin")
+	assert.Contains(t, lines[4], "|  ==  |  select ? from foo")
+	assert.Contains(t, lines[5], "|    ==|  commit")
+
+	assert.True(t, strings.HasPrefix(waterfall.SVG(), "<svg "))
+}